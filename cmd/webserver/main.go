@@ -10,6 +10,8 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"time"
+	_ "time/tzdata" // time zones for CSV export, not included in scratch image
 
 	"github.com/EricNeid/go-webserver/server"
 	"github.com/gin-gonic/gin"
@@ -27,6 +29,9 @@ var (
 	dbName     string = "localdb"
 
 	logFile string = ""
+
+	tripConfig     = server.DefaultTripConfig
+	distanceConfig = server.DefaultDistanceConfig
 )
 
 func init() {
@@ -69,7 +74,7 @@ func main() {
 
 	// create server
 	gin.SetMode(gin.ReleaseMode)
-	server := server.NewApplicationServer(db, listenAddr)
+	server := server.NewApplicationServer(db, listenAddr, server.WithTripConfig(tripConfig), server.WithDistanceConfig(distanceConfig))
 	go server.GracefullShutdown(quit, done)
 
 	log.Println("Creating database structure", listenAddr)
@@ -115,6 +120,34 @@ func readConfigFromEnvironment() {
 	if value, isSet := os.LookupEnv("LOG_FILE"); isSet {
 		logFile = value
 	}
+
+	if value, isSet := os.LookupEnv("TRIP_STOP_SPEED"); isSet {
+		tripConfig.StopSpeed, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if value, isSet := os.LookupEnv("TRIP_STOP_RADIUS"); isSet {
+		tripConfig.StopRadius, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if value, isSet := os.LookupEnv("TRIP_MIN_STOP_DURATION"); isSet {
+		tripConfig.MinStopDuration, _ = time.ParseDuration(value)
+	}
+
+	if value, isSet := os.LookupEnv("TRIP_MIN_DISTANCE"); isSet {
+		tripConfig.MinTripDistance, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if value, isSet := os.LookupEnv("TRIP_UPDATE_INTERVAL"); isSet {
+		tripConfig.UpdateInterval, _ = time.ParseDuration(value)
+	}
+
+	if value, isSet := os.LookupEnv("DISTANCE_MIN"); isSet {
+		distanceConfig.MinDistance, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if value, isSet := os.LookupEnv("DISTANCE_MAX_ACCURACY"); isSet {
+		distanceConfig.MaxAccuracy, _ = strconv.ParseFloat(value, 64)
+	}
 }
 
 func readConfigFromCli() {
@@ -125,6 +158,13 @@ func readConfigFromCli() {
 	flag.StringVar(&dbPass, "db-pass", dbPass, "database user password")
 	flag.StringVar(&dbName, "db-name", dbName, "database name")
 	flag.StringVar(&logFile, "log-file", logFile, "Optional: write log to this file")
+	flag.Float64Var(&tripConfig.StopSpeed, "trip-stop-speed", tripConfig.StopSpeed, "speed in m/s up to which a vehicle is considered stopped")
+	flag.Float64Var(&tripConfig.StopRadius, "trip-stop-radius", tripConfig.StopRadius, "radius in m a stopped vehicle stays within")
+	flag.DurationVar(&tripConfig.MinStopDuration, "trip-min-stop-duration", tripConfig.MinStopDuration, "minimum duration of a stop")
+	flag.Float64Var(&tripConfig.MinTripDistance, "trip-min-distance", tripConfig.MinTripDistance, "minimum distance in m of a trip between stops")
+	flag.DurationVar(&tripConfig.UpdateInterval, "trip-update-interval", tripConfig.UpdateInterval, "interval of updating trips with new states, 0 disables it")
+	flag.Float64Var(&distanceConfig.MinDistance, "distance-min", distanceConfig.MinDistance, "movements in m below which distances are ignored as GPS jitter")
+	flag.Float64Var(&distanceConfig.MaxAccuracy, "distance-max-accuracy", distanceConfig.MaxAccuracy, "Optional: ignore positions with worse accuracy in m when computing distances")
 
 	flag.Parse()
 }
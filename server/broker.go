@@ -0,0 +1,155 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/paulmach/orb"
+)
+
+// subscriptionFilter selects the states delivered to a subscriber. Empty fields match all states.
+type subscriptionFilter struct {
+	VehicleIDs []int64
+	BBox       *orb.Bound
+}
+
+// matches reports whether the state is selected by the filter.
+func (f subscriptionFilter) matches(state vehicleState) bool {
+	if len(f.VehicleIDs) > 0 {
+		found := false
+		for _, id := range f.VehicleIDs {
+			if id == state.VehicleID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.BBox != nil {
+		position, ok := state.Position.Geometry().(orb.Point)
+		if !ok {
+			return false
+		}
+		for _, b := range splitAtAntimeridian(*f.BBox) {
+			if b.Contains(position) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// subscriber receives the states and geofence events published by a broker.
+type subscriber interface {
+	// deliver queues the updates without blocking and reports whether the subscriber keeps up.
+	// Events are published together with the states causing them.
+	deliver(states []vehicleState, events []geofenceEvent) bool
+	// close is called once when the subscriber is removed from the broker.
+	close()
+}
+
+// broker distributes newly stored vehicle states and geofence events to live subscribers.
+// Publishing never blocks, subscribers that do not keep up are removed.
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[subscriber]struct{}
+	closed      bool
+	// position is the cursor of the last state published, nil until known.
+	position *commitCursor
+}
+
+func newBroker() *broker {
+	return &broker{subscribers: map[subscriber]struct{}{}}
+}
+
+// subscribe registers the subscriber and returns the position of the broker, so that the subscriber receives
+// all states after it. If the broker is closed, the subscriber is closed immediately.
+func (b *broker) subscribe(s subscriber) *commitCursor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.close()
+		return b.position
+	}
+	b.subscribers[s] = struct{}{}
+	return b.position
+}
+
+// advance sets the position of the broker, e.g. when starting to publish from cursor.
+func (b *broker) advance(cursor commitCursor) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.position == nil || b.position.before(cursor) {
+		b.position = &cursor
+	}
+}
+
+// unsubscribe removes and closes the subscriber, if still registered.
+func (b *broker) unsubscribe(s subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		s.close()
+	}
+}
+
+// publish delivers the states and the geofence events they caused to all subscribers.
+// The states have to be ordered by commit, the position of the broker is advanced to the last one.
+func (b *broker) publish(states []vehicleState, events []geofenceEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(states) > 0 {
+		cursor := states[len(states)-1].Cursor
+		b.position = &cursor
+	}
+	for s := range b.subscribers {
+		if !s.deliver(states, events) {
+			// subscriber is lagging
+			delete(b.subscribers, s)
+			s.close()
+		}
+	}
+}
+
+// close removes all subscribers and rejects new ones, e.g. on shutdown.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		s.close()
+	}
+}
+
+// stateSubscription is a subscriber buffering the states matching its filter in a channel.
+// The channel is closed when the subscription is removed, e.g. if the buffer is full.
+type stateSubscription struct {
+	filter subscriptionFilter
+	states chan vehicleState
+}
+
+func newStateSubscription(filter subscriptionFilter, size int) *stateSubscription {
+	return &stateSubscription{filter: filter, states: make(chan vehicleState, size)}
+}
+
+func (s *stateSubscription) deliver(states []vehicleState, events []geofenceEvent) bool {
+	for _, state := range states {
+		if !s.filter.matches(state) {
+			continue
+		}
+		select {
+		case s.states <- state:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *stateSubscription) close() {
+	close(s.states)
+}
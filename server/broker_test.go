@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func brokerTestState(id, vehicleID int64, position orb.Point) vehicleState {
+	return vehicleState{ID: id, Cursor: commitCursor{TxID: id, Index: 1}, VehicleID: vehicleID, Position: *geojson.NewGeometry(position), Timestamp: time.Now()}
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	state := brokerTestState(1, 7, orb.Point{175, -15})
+	for name, test := range map[string]struct {
+		filter   subscriptionFilter
+		expected bool
+	}{
+		"empty":                      {subscriptionFilter{}, true},
+		"vehicle":                    {subscriptionFilter{VehicleIDs: []int64{3, 7}}, true},
+		"other vehicle":              {subscriptionFilter{VehicleIDs: []int64{3}}, false},
+		"bbox crossing antimeridian": {subscriptionFilter{BBox: &orb.Bound{Min: orb.Point{170, -20}, Max: orb.Point{-170, -10}}}, true},
+		"other bbox":                 {subscriptionFilter{BBox: &orb.Bound{Min: orb.Point{-170, -20}, Max: orb.Point{170, -10}}}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// action
+			result := test.filter.matches(state)
+			// verify
+			verify.Equals(t, test.expected, result)
+		})
+	}
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("publish to matching subscribers", func(t *testing.T) {
+		// arrange
+		unit := newBroker()
+		all := newStateSubscription(subscriptionFilter{}, 10)
+		some := newStateSubscription(subscriptionFilter{VehicleIDs: []int64{2}}, 10)
+		unit.subscribe(all)
+		unit.subscribe(some)
+		// action
+		unit.publish([]vehicleState{brokerTestState(1, 1, orb.Point{20, 30}), brokerTestState(2, 2, orb.Point{20, 30})}, nil)
+		// verify
+		verify.Equals(t, 2, len(all.states))
+		verify.Equals(t, 1, len(some.states))
+		verify.Equals(t, int64(2), (<-some.states).ID)
+	})
+
+	t.Run("lagging subscriber is closed", func(t *testing.T) {
+		// arrange
+		unit := newBroker()
+		sub := newStateSubscription(subscriptionFilter{}, 1)
+		unit.subscribe(sub)
+		// action
+		unit.publish([]vehicleState{brokerTestState(1, 1, orb.Point{20, 30}), brokerTestState(2, 1, orb.Point{20, 30})}, nil)
+		// verify
+		<-sub.states
+		_, ok := <-sub.states
+		verify.Assert(t, !ok, "subscription is still open")
+		unit.unsubscribe(sub) // must not close twice
+	})
+
+	t.Run("close", func(t *testing.T) {
+		// arrange
+		unit := newBroker()
+		sub := newStateSubscription(subscriptionFilter{}, 1)
+		unit.subscribe(sub)
+		// action
+		unit.close()
+		late := newStateSubscription(subscriptionFilter{}, 1)
+		unit.subscribe(late)
+		// verify
+		_, ok := <-sub.states
+		verify.Assert(t, !ok, "subscription is still open")
+		_, ok = <-late.states
+		verify.Assert(t, !ok, "late subscription is open")
+	})
+
+	t.Run("position", func(t *testing.T) {
+		// arrange
+		unit := newBroker()
+		// action
+		unknown := unit.subscribe(newStateSubscription(subscriptionFilter{}, 10))
+		unit.advance(commitCursor{TxID: 1, Index: 1})
+		unit.publish([]vehicleState{brokerTestState(2, 1, orb.Point{20, 30}), brokerTestState(3, 1, orb.Point{20, 30})}, nil)
+		unit.advance(commitCursor{TxID: 1, Index: 1})
+		position := unit.subscribe(newStateSubscription(subscriptionFilter{}, 10))
+		// verify
+		verify.Assert(t, unknown == nil, "unexpected position %v", unknown)
+		verify.Equals(t, &commitCursor{TxID: 3, Index: 1}, position)
+	})
+}
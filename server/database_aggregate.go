@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/paulmach/orb"
+)
+
+// mercatorMaxLat is the latitude up to which positions can be projected to web mercator.
+const mercatorMaxLat = 85.0511
+
+// maxGridCells is the largest number of cells a grid may span, see gridCellCount.
+// When grouped by time, it limits the cells of all time buckets together, see timeBucketCount.
+const maxGridCells = 250000
+
+// gridCell is a square cell of a grid in web mercator metres, see getVehicleStateGrid.
+type gridCell struct {
+	// X and Y index the cell, which spans [X*cellSize, (X+1)*cellSize) horizontally.
+	X, Y int64
+	// Start of the time bucket, if grouped by time.
+	Start *time.Time
+	// Count of states inside the cell.
+	Count int64
+	// Vehicles is the number of distinct vehicles inside the cell.
+	Vehicles int64
+}
+
+// gridExtent returns the web mercator extent of bbox, or of the whole world if bbox is nil.
+// Latitudes are limited to mercatorMaxLat, a bbox crossing the antimeridian extends beyond webMercatorMax.
+func gridExtent(bbox *orb.Bound) orb.Bound {
+	bound := orb.Bound{Min: orb.Point{-180, -mercatorMaxLat}, Max: orb.Point{180, mercatorMaxLat}}
+	if bbox != nil {
+		bound = *bbox
+	}
+	clamp := func(p orb.Point) orb.Point {
+		return orb.Point{p.Lon(), math.Max(-mercatorMaxLat, math.Min(mercatorMaxLat, p.Lat()))}
+	}
+	extent := orb.Bound{Min: lonLatToMercator(clamp(bound.Min)), Max: lonLatToMercator(clamp(bound.Max))}
+	if extent.Min.X() > extent.Max.X() {
+		extent.Max[0] += 2 * webMercatorMax
+	}
+	return extent
+}
+
+// gridCellSize converts a cell size in metres to web mercator metres. Mercator stretches distances
+// by 1/cos(lat), so the cells are metres wide at the centre latitude of extent and narrower towards the poles.
+func gridCellSize(extent orb.Bound, metres float64) float64 {
+	lat := mercatorToLonLat(extent.Center()).Lat()
+	return metres / math.Cos(lat*math.Pi/180)
+}
+
+// gridCellCount returns the number of cells of the given size in web mercator metres needed to cover extent.
+func gridCellCount(extent orb.Bound, cellSize float64) float64 {
+	columns := math.Max(1, math.Ceil(extent.Right()/cellSize)-math.Floor(extent.Left()/cellSize))
+	rows := math.Max(1, math.Ceil(extent.Top()/cellSize)-math.Floor(extent.Bottom()/cellSize))
+	return columns * rows
+}
+
+// timeBucketCount returns the largest number of hour or day buckets the time range from to may span.
+func timeBucketCount(from, to time.Time, bucket string) float64 {
+	size := time.Hour
+	if bucket == timeBucketDay {
+		size = 24 * time.Hour
+	}
+	// one more for a range not aligned to the buckets and one for days shortened by daylight saving time
+	return math.Max(0, math.Floor(float64(to.Sub(from))/float64(size))) + 2
+}
+
+// getVehicleStateGrid counts the states matching filter in the cells of a square grid with
+// the given cell size in web mercator metres. If bucket is hour or day, cells are counted separately
+// for each hour or day starting in loc. Hours are told apart by their UTC offset, so that the hour repeated
+// when daylight saving time ends yields two buckets. Empty cells are omitted and positions beyond mercatorMaxLat ignored.
+func getVehicleStateGrid(logger *log.Logger, db *pgxpool.Pool, filter vehicleStateFilter, cellSize float64, bucket string, loc *time.Location) ([]gridCell, error) {
+	conditions, args := filter.conditions(nil)
+	args = append(args, mercatorMaxLat)
+	conditions = append(conditions, fmt.Sprintf("abs(ST_Y(position::geometry)) <= $%d", len(args)))
+	args = append(args, cellSize)
+	cell := fmt.Sprintf(
+		"floor(ST_X(ST_Transform(position::geometry, 3857)) / $%[1]d)::bigint AS x, floor(ST_Y(ST_Transform(position::geometry, 3857)) / $%[1]d)::bigint AS y",
+		len(args),
+	)
+	start := "NULL::timestamp AS start, 0 AS utc_offset"
+	if bucket != "" {
+		args = append(args, bucket, loc.String())
+		local := fmt.Sprintf("timezone($%d, state_timestamp AT TIME ZONE 'UTC')", len(args))
+		offset := "0"
+		if bucket == timeBucketHour {
+			offset = fmt.Sprintf("extract(epoch FROM %s - state_timestamp)::integer", local)
+		}
+		start = fmt.Sprintf("date_trunc($%d, %s) AS start, %s AS utc_offset", len(args)-1, local, offset)
+	}
+
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT x, y, start, utc_offset, count(*), count(DISTINCT vehicle_id)
+			FROM (SELECT %s, %s, vehicle_id FROM %s %s) AS cells
+			GROUP BY start, utc_offset, x, y
+			ORDER BY start, utc_offset DESC, x, y`,
+			cell,
+			start,
+			tableVehicleState,
+			whereClause(conditions),
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// collect result
+	cells := make([]gridCell, 0)
+	for rows.Next() {
+		var c gridCell
+		var local *time.Time
+		var offset int
+		err := rows.Scan(&c.X, &c.Y, &local, &offset, &c.Count, &c.Vehicles)
+		if err != nil {
+			return cells, err
+		}
+		if local != nil && bucket == timeBucketHour {
+			// start is returned as local time with its offset to UTC
+			t := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, time.UTC).Add(-time.Duration(offset) * time.Second).In(loc)
+			c.Start = &t
+		} else if local != nil {
+			// start is returned as local time of loc
+			t := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+			c.Start = &t
+		}
+		cells = append(cells, c)
+	}
+	return cells, rows.Err()
+}
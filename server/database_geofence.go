@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const tableGeofence = "geofence"
+
+// geofenceColumns lists the columns read by scanGeofence, in order.
+const geofenceColumns = "id, name, category, ST_AsBinary(area)"
+
+func createTableGeofence(logger *log.Logger, db *pgxpool.Pool) error {
+	logger.Printf("Creating table %s\n", tableGeofence)
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s
+			(
+				id       bigserial PRIMARY KEY,
+				name     varchar NOT NULL,
+				category varchar NOT NULL DEFAULT '',
+				area     GEOGRAPHY(GEOMETRY, 4326) NOT NULL
+			)`,
+			tableGeofence,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_area_idx ON %[1]s USING GIST (area)`,
+			tableGeofence,
+		),
+	)
+	return err
+}
+
+func addGeofence(logger *log.Logger, db *pgxpool.Pool, fence geofence) (int64, error) {
+	var id int64
+	err := db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`INSERT INTO %s (name, category, area) VALUES ($1, $2, ST_GeomFromWKB($3)) RETURNING id`,
+			tableGeofence,
+		),
+		fence.Name,
+		fence.Category,
+		wkb.Value(fence.Area.Geometry()),
+	).Scan(&id)
+	return id, err
+}
+
+// updateGeofence replaces the geofence that is associated with the given id.
+// If no geofence exists, ErrorNotFound is returned.
+func updateGeofence(logger *log.Logger, db *pgxpool.Pool, id int64, fence geofence) error {
+	tag, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`UPDATE %s SET name=$2, category=$3, area=ST_GeomFromWKB($4) WHERE id=$1`,
+			tableGeofence,
+		),
+		id,
+		fence.Name,
+		fence.Category,
+		wkb.Value(fence.Area.Geometry()),
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = ErrorNotFound
+	}
+	return err
+}
+
+func deleteGeofence(logger *log.Logger, db *pgxpool.Pool, id int64) error {
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`DELETE FROM %s WHERE id=$1`,
+			tableGeofence,
+		),
+		id,
+	)
+	return err
+}
+
+// scanGeofence reads a single row selected with geofenceColumns.
+func scanGeofence(row pgx.Row) (geofence, error) {
+	var fence geofence
+	area := wkb.Scanner(nil)
+	err := row.Scan(&fence.ID, &fence.Name, &fence.Category, area)
+	if err == nil {
+		fence.Area = *geojson.NewGeometry(area.Geometry)
+	}
+	return fence, err
+}
+
+// getGeofence returns the geofence that is associated with the given id.
+// If no geofence exists, ErrorNotFound is returned.
+func getGeofence(logger *log.Logger, db *pgxpool.Pool, id int64) (geofence, error) {
+	fence, err := scanGeofence(db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT %s FROM %s WHERE id=$1`,
+			geofenceColumns,
+			tableGeofence,
+		),
+		id,
+	))
+	if err == pgx.ErrNoRows {
+		err = ErrorNotFound // return custom error
+	}
+	return fence, err
+}
+
+func getGeofences(logger *log.Logger, db *pgxpool.Pool) ([]geofence, error) {
+	return queryGeofences(
+		db,
+		fmt.Sprintf(
+			`SELECT %s FROM %s ORDER BY id`,
+			geofenceColumns,
+			tableGeofence,
+		),
+	)
+}
+
+// getGeofencesContaining returns all geofences that contain the given position,
+// including those that have it on their boundary.
+func getGeofencesContaining(logger *log.Logger, db *pgxpool.Pool, position orb.Point) ([]geofence, error) {
+	return queryGeofences(
+		db,
+		fmt.Sprintf(
+			`SELECT %s FROM %s WHERE ST_Covers(area, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography) ORDER BY id`,
+			geofenceColumns,
+			tableGeofence,
+		),
+		position.Lon(),
+		position.Lat(),
+	)
+}
+
+// queryGeofences collects all rows returned by a query selecting geofenceColumns.
+func queryGeofences(db *pgxpool.Pool, sql string, args ...interface{}) ([]geofence, error) {
+	var fences []geofence
+	rows, err := db.Query(context.Background(), sql, args...)
+	if err != nil {
+		return fences, err
+	}
+	defer rows.Close()
+
+	// collect result
+	for rows.Next() {
+		fence, err := scanGeofence(rows)
+		if err != nil {
+			return fences, err
+		}
+		fences = append(fences, fence)
+	}
+
+	return fences, rows.Err()
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const tableGeofenceEvent = "geofence_events"
+
+const (
+	geofenceEventEnter = "enter"
+	geofenceEventExit  = "exit"
+)
+
+func createTableGeofenceEvent(logger *log.Logger, db *pgxpool.Pool) error {
+	logger.Printf("Creating table %s\n", tableGeofenceEvent)
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s
+			(
+				id               bigserial PRIMARY KEY,
+				geofence_id      bigint NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+				vehicle_id       bigint NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+				vehicle_state_id bigint NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+				event_type       varchar NOT NULL CHECK (event_type IN ('%s', '%s')),
+				event_timestamp  TIMESTAMP NOT NULL
+			)`,
+			tableGeofenceEvent,
+			tableGeofence,
+			tableVehicle,
+			tableVehicleState,
+			geofenceEventEnter,
+			geofenceEventExit,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"geofence_id", "vehicle_id"} {
+		_, err = db.Exec(
+			context.Background(),
+			fmt.Sprintf(
+				`CREATE INDEX IF NOT EXISTS %[1]s_%[2]s_idx ON %[1]s (%[2]s, event_timestamp)`,
+				tableGeofenceEvent,
+				column,
+			),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	// used to replace the events of a state, see detectGeofenceEvents
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_vehicle_state_id_idx ON %[1]s (vehicle_state_id)`,
+			tableGeofenceEvent,
+		),
+	)
+	return err
+}
+
+// detectGeofenceEvents compares each of the given, newly stored vehicle states with
+// the preceding state of the same vehicle, by timestamp and states of the same timestamp by id.
+// For every geofence that one of both positions is inside of and the other is not, an enter or
+// exit event is stored. A state without predecessor is treated as coming from outside of all geofences.
+// A state stored out of order becomes the predecessor of the following state, whose events are
+// detected again. The stored events are returned, without dwell time.
+func detectGeofenceEvents(logger *log.Logger, tx pgx.Tx, stateIDs []int64) ([]geofenceEvent, error) {
+	ctx := context.Background()
+	// the states following the new ones compare with a different predecessor now
+	_, err := tx.Exec(
+		ctx,
+		fmt.Sprintf(
+			`DELETE FROM %[2]s WHERE vehicle_state_id IN (
+				SELECT (
+					SELECT n.id FROM %[1]s n
+					WHERE n.vehicle_id = s.vehicle_id AND (n.state_timestamp, n.id) > (s.state_timestamp, s.id)
+					ORDER BY n.state_timestamp, n.id LIMIT 1
+				) FROM %[1]s s WHERE s.id = ANY($1)
+			)`,
+			tableVehicleState,
+			tableGeofenceEvent,
+		),
+		stateIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(
+		ctx,
+		fmt.Sprintf(
+			`WITH affected AS (
+				SELECT id FROM %[1]s WHERE id = ANY($1)
+				UNION
+				SELECT (
+					SELECT n.id FROM %[1]s n
+					WHERE n.vehicle_id = s.vehicle_id AND (n.state_timestamp, n.id) > (s.state_timestamp, s.id)
+					ORDER BY n.state_timestamp, n.id LIMIT 1
+				) FROM %[1]s s WHERE s.id = ANY($1)
+			), added AS (
+				SELECT s.id, s.vehicle_id, s.position, s.state_timestamp,
+					(
+						SELECT p.position FROM %[1]s p
+						WHERE p.vehicle_id = s.vehicle_id AND (p.state_timestamp, p.id) < (s.state_timestamp, s.id)
+						ORDER BY p.state_timestamp DESC, p.id DESC LIMIT 1
+					) AS previous_position
+				FROM %[1]s s WHERE s.id IN (SELECT id FROM affected)
+			), transitions AS (
+				SELECT g.id AS geofence_id, a.vehicle_id, a.id AS vehicle_state_id, a.state_timestamp,
+					ST_Covers(g.area, a.position) AS is_inside,
+					COALESCE(ST_Covers(g.area, a.previous_position), false) AS was_inside
+				FROM added a JOIN %[2]s g
+				ON ST_Covers(g.area, a.position) OR ST_Covers(g.area, a.previous_position)
+			)
+			INSERT INTO %[3]s (geofence_id, vehicle_id, vehicle_state_id, event_type, event_timestamp)
+			SELECT geofence_id, vehicle_id, vehicle_state_id,
+				CASE WHEN is_inside THEN '%[4]s' ELSE '%[5]s' END, state_timestamp
+			FROM transitions WHERE is_inside <> was_inside
+			ORDER BY state_timestamp, vehicle_state_id
+			RETURNING id, geofence_id, vehicle_id, vehicle_state_id, event_type, event_timestamp, NULL::double precision`,
+			tableVehicleState,
+			tableGeofence,
+			tableGeofenceEvent,
+			geofenceEventEnter,
+			geofenceEventExit,
+		),
+		stateIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectGeofenceEvents(rows)
+}
+
+// geofenceEventFilter restricts the events returned by getGeofenceEvents.
+// Zero values do not restrict the result.
+type geofenceEventFilter struct {
+	GeofenceID int64
+	VehicleID  int64
+	// From selects events at or after the given time.
+	From *time.Time
+	// To selects events before the given time.
+	To *time.Time
+}
+
+// getGeofenceEvents returns the events matching the filter, oldest event first.
+// Exit events carry the dwell time since the preceding enter event of the same
+// vehicle and geofence, even if that enter event is outside of the time range.
+func getGeofenceEvents(logger *log.Logger, db *pgxpool.Pool, filter geofenceEventFilter) ([]geofenceEvent, error) {
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	// vehicle and geofence are partition keys and can be filtered before computing the dwell time
+	var partitionConditions, conditions []string
+	if filter.GeofenceID != 0 {
+		partitionConditions = append(partitionConditions, "geofence_id = "+arg(filter.GeofenceID))
+	}
+	if filter.VehicleID != 0 {
+		partitionConditions = append(partitionConditions, "vehicle_id = "+arg(filter.VehicleID))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "event_timestamp >= "+arg(filter.From.UTC()))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "event_timestamp < "+arg(filter.To.UTC()))
+	}
+
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id, geofence_id, vehicle_id, vehicle_state_id, event_type, event_timestamp,
+				CASE WHEN event_type = '%[1]s' AND previous_type = '%[2]s'
+					THEN EXTRACT(EPOCH FROM event_timestamp - previous_timestamp)::double precision
+				END
+			FROM (
+				SELECT *,
+					LAG(event_type) OVER w AS previous_type,
+					LAG(event_timestamp) OVER w AS previous_timestamp
+				FROM %[3]s %[4]s
+				WINDOW w AS (PARTITION BY vehicle_id, geofence_id ORDER BY event_timestamp, id)
+			) AS events %[5]s
+			ORDER BY event_timestamp, id`,
+			geofenceEventExit,
+			geofenceEventEnter,
+			tableGeofenceEvent,
+			whereClause(partitionConditions),
+			whereClause(conditions),
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectGeofenceEvents(rows)
+}
+
+// getGeofenceEventsOfVehicleStates returns the events caused by the given vehicle states, without dwell time
+// as returned by detectGeofenceEvents.
+func getGeofenceEventsOfVehicleStates(logger *log.Logger, db *pgxpool.Pool, stateIDs []int64) ([]geofenceEvent, error) {
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id, geofence_id, vehicle_id, vehicle_state_id, event_type, event_timestamp, NULL::double precision
+			FROM %s WHERE vehicle_state_id = ANY($1)
+			ORDER BY event_timestamp, vehicle_state_id, id`,
+			tableGeofenceEvent,
+		),
+		stateIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectGeofenceEvents(rows)
+}
+
+// collectGeofenceEvents reads all rows selecting the event columns followed by the dwell time.
+func collectGeofenceEvents(rows pgx.Rows) ([]geofenceEvent, error) {
+	var events []geofenceEvent
+	defer rows.Close()
+
+	// collect result
+	for rows.Next() {
+		var event geofenceEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.GeofenceID,
+			&event.VehicleID,
+			&event.VehicleStateID,
+			&event.Type,
+			&event.Timestamp,
+			&event.Dwell,
+		)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
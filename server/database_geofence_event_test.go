@@ -0,0 +1,110 @@
+package server
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGeofenceEventSchemaIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+
+	var err error
+	t.Run("creating table", func(t *testing.T) {
+		// action
+		err = createTableVehicle(logger, db)
+		verify.Ok(t, err)
+		err = createTableVehicleState(logger, db)
+		verify.Ok(t, err)
+		err = createTableVehicleStateCommit(logger, db)
+		verify.Ok(t, err)
+		err = createTableGeofence(logger, db)
+		verify.Ok(t, err)
+		err = createTableGeofenceEvent(logger, db)
+		// verify
+		verify.Ok(t, err)
+	})
+
+	vehicleID, _ := addVehicle(logger, db, vehicle{Name: "truck"})
+	depot := orb.Polygon{{{19, 29}, {21, 29}, {21, 31}, {19, 31}, {19, 29}}}
+	geofenceID, _ := addGeofence(logger, db, geofence{Name: "depot", Area: *geojson.NewGeometry(depot)})
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+
+	t.Run("adding states should detect transitions", func(t *testing.T) {
+		// arrange
+		positions := []orb.Point{{10, 10}, {20, 30}, {20.5, 30}, {25, 30}}
+		// action
+		for i, position := range positions {
+			_, err := addVehicleState(logger, db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(position), Timestamp: start.Add(time.Duration(i) * 10 * time.Minute)})
+			verify.Ok(t, err)
+		}
+		// verify
+		events, err := getGeofenceEvents(logger, db, geofenceEventFilter{VehicleID: vehicleID})
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(events))
+		verify.Equals(t, geofenceEventEnter, events[0].Type)
+		verify.Equals(t, geofenceID, events[0].GeofenceID)
+		verify.Equals(t, start.Add(10*time.Minute), events[0].Timestamp)
+		verify.Assert(t, events[0].Dwell == nil, "enter event has dwell time")
+		verify.Equals(t, geofenceEventExit, events[1].Type)
+		verify.Equals(t, 1200.0, *events[1].Dwell)
+	})
+
+	t.Run("getting events of geofence in time range", func(t *testing.T) {
+		// arrange
+		from := start.Add(15 * time.Minute)
+		// action
+		events, err := getGeofenceEvents(logger, db, geofenceEventFilter{GeofenceID: geofenceID, From: &from})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(events))
+		verify.Equals(t, geofenceEventExit, events[0].Type)
+		verify.Equals(t, 1200.0, *events[0].Dwell)
+	})
+
+	t.Run("adding state out of order should update the following state", func(t *testing.T) {
+		// action
+		_, err := addVehicleState(logger, db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 29.5}), Timestamp: start.Add(-10 * time.Minute)})
+		verify.Ok(t, err)
+		// verify
+		events, err := getGeofenceEvents(logger, db, geofenceEventFilter{VehicleID: vehicleID})
+		verify.Ok(t, err)
+		var types []string
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		verify.Equals(t, []string{geofenceEventEnter, geofenceEventExit, geofenceEventEnter, geofenceEventExit}, types)
+		verify.Equals(t, start.Add(-10*time.Minute), events[0].Timestamp)
+		verify.Equals(t, start, events[1].Timestamp)
+		verify.Equals(t, 600.0, *events[1].Dwell)
+		verify.Equals(t, 1200.0, *events[3].Dwell)
+	})
+
+	t.Run("adding state with same timestamp should follow the existing one", func(t *testing.T) {
+		// action
+		_, err := addVehicleState(logger, db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{10, 10}), Timestamp: start.Add(20 * time.Minute)})
+		verify.Ok(t, err)
+		// verify
+		events, err := getGeofenceEvents(logger, db, geofenceEventFilter{VehicleID: vehicleID, From: &start})
+		verify.Ok(t, err)
+		var types []string
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		verify.Equals(t, []string{geofenceEventExit, geofenceEventEnter, geofenceEventExit}, types)
+		verify.Equals(t, start.Add(20*time.Minute), events[2].Timestamp)
+	})
+}
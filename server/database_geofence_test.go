@@ -0,0 +1,93 @@
+package server
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGeofenceSchemaIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+
+	var err error
+	t.Run("creating table", func(t *testing.T) {
+		// action
+		err = createTableGeofence(logger, db)
+		// verify
+		verify.Ok(t, err)
+	})
+
+	depot := orb.Polygon{{{19, 29}, {21, 29}, {21, 31}, {19, 31}, {19, 29}}}
+	var id int64
+	t.Run("adding geofence", func(t *testing.T) {
+		// arrange
+		fence := geofence{Name: "depot", Category: "depot", Area: *geojson.NewGeometry(depot)}
+		// action
+		id, err = addGeofence(logger, db, fence)
+		// verify
+		verify.Ok(t, err)
+		verify.Assert(t, id > 0, "no id returned")
+	})
+
+	t.Run("getting geofence by id", func(t *testing.T) {
+		// action
+		result, err := getGeofence(logger, db, id)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, "depot", result.Name)
+		verify.Equals(t, depot, result.Area.Geometry())
+	})
+
+	t.Run("updating geofence", func(t *testing.T) {
+		// arrange
+		area := orb.MultiPolygon{depot, {{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}}
+		fence := geofence{Name: "depots", Category: "depot", Area: *geojson.NewGeometry(area)}
+		// action
+		err := updateGeofence(logger, db, id, fence)
+		// verify
+		verify.Ok(t, err)
+		result, err := getGeofence(logger, db, id)
+		verify.Ok(t, err)
+		verify.Equals(t, area, result.Area.Geometry())
+	})
+
+	t.Run("getting all geofences", func(t *testing.T) {
+		// action
+		result, err := getGeofences(logger, db)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result))
+	})
+
+	t.Run("getting geofences containing position", func(t *testing.T) {
+		// action
+		inside, err := getGeofencesContaining(logger, db, orb.Point{20, 30})
+		verify.Ok(t, err)
+		outside, err := getGeofencesContaining(logger, db, orb.Point{25, 30})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(inside))
+		verify.Equals(t, 0, len(outside))
+	})
+
+	t.Run("delete geofence by id", func(t *testing.T) {
+		// action
+		err := deleteGeofence(logger, db, id)
+		// verify
+		verify.Ok(t, err)
+		_, err = getGeofence(logger, db, id)
+		verify.Equals(t, ErrorNotFound, err)
+	})
+}
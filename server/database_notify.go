@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// channelVehicleStates is the notification channel newly stored vehicle states are announced on.
+const channelVehicleStates = "vehicle_states"
+
+// tableVehicleStateCommit records the states stored by each transaction, see notifyVehicleStates.
+const tableVehicleStateCommit = "vehicle_state_commits"
+
+// commitHorizon selects the id of the oldest transaction still in progress. Every transaction with a smaller
+// id has ended, so no commit below it can show up later.
+const commitHorizon = "txid_snapshot_xmin(txid_current_snapshot())"
+
+// commitCursor is the position of a state in the commit log: the id of the transaction that stored it and
+// its index within that transaction. Readers only consume commits below commitHorizon, so the cursor
+// increases with commits, unlike state ids, which are reserved before the states are committed.
+type commitCursor struct {
+	TxID  int64
+	Index int64
+}
+
+// String formats the cursor as used for event ids, e.g. "1234.5".
+func (cursor commitCursor) String() string {
+	return strconv.FormatInt(cursor.TxID, 10) + "." + strconv.FormatInt(cursor.Index, 10)
+}
+
+// before reports whether cursor precedes other.
+func (cursor commitCursor) before(other commitCursor) bool {
+	return cursor.TxID < other.TxID || cursor.TxID == other.TxID && cursor.Index < other.Index
+}
+
+// parseCommitCursor reads a cursor formatted by commitCursor.String.
+func parseCommitCursor(value string) (commitCursor, error) {
+	var cursor commitCursor
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return cursor, fmt.Errorf("invalid commit cursor %q", value)
+	}
+	txID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || txID < 0 {
+		return cursor, fmt.Errorf("invalid commit cursor %q", value)
+	}
+	index, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || index < 0 {
+		return cursor, fmt.Errorf("invalid commit cursor %q", value)
+	}
+	return commitCursor{TxID: txID, Index: index}, nil
+}
+
+func createTableVehicleStateCommit(logger *log.Logger, db *pgxpool.Pool) error {
+	logger.Printf("Creating table %s\n", tableVehicleStateCommit)
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s
+			(
+				tx_id     bigint PRIMARY KEY DEFAULT txid_current(),
+				state_ids bigint[] NOT NULL
+			)`,
+			tableVehicleStateCommit,
+		),
+	)
+	return err
+}
+
+// notifyVehicleStates records the ids of newly stored states in a single row of tableVehicleStateCommit,
+// keyed by the id of tx, and announces the commit on channelVehicleStates. Transactions do not wait for each
+// other, readers order commits by commitCursor instead. This has to be the last step before committing tx.
+// Notifications are only delivered once tx is committed.
+func notifyVehicleStates(logger *log.Logger, tx pgx.Tx, ids []int64) error {
+	_, err := tx.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`WITH c AS (INSERT INTO %s (state_ids) VALUES ($2) RETURNING tx_id)
+			SELECT pg_notify($1, tx_id::text) FROM c`,
+			tableVehicleStateCommit,
+		),
+		channelVehicleStates,
+		ids,
+	)
+	return err
+}
+
+// listenVehicleStates subscribes conn to channelVehicleStates, see notifyVehicleStates.
+func listenVehicleStates(logger *log.Logger, conn *pgx.Conn) error {
+	_, err := conn.Exec(context.Background(), "LISTEN "+channelVehicleStates)
+	return err
+}
+
+// getLastCommitCursor returns the cursor of the last state below commitHorizon,
+// or the zero cursor if there are none.
+func getLastCommitCursor(logger *log.Logger, db *pgxpool.Pool) (commitCursor, error) {
+	var cursor commitCursor
+	err := db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT tx_id, cardinality(state_ids) FROM %s WHERE tx_id < %s ORDER BY tx_id DESC LIMIT 1`,
+			tableVehicleStateCommit,
+			commitHorizon,
+		),
+	).Scan(&cursor.TxID, &cursor.Index)
+	if err == pgx.ErrNoRows {
+		return cursor, nil
+	}
+	return cursor, err
+}
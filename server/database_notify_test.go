@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/jackc/pgx/v4"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestNotifyVehicleStatesIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+	createTableVehicle(logger, db)
+	createTableVehicleState(logger, db)
+	createTableVehicleStateCommit(logger, db)
+	createTableGeofence(logger, db)
+	createTableGeofenceEvent(logger, db)
+	vehicleID, _ := addVehicle(logger, db, vehicle{Name: "truck"})
+	conn, err := pgx.ConnectConfig(context.Background(), db.Config().ConnConfig)
+	verify.Ok(t, err)
+	defer conn.Close(context.Background())
+	verify.Ok(t, listenVehicleStates(logger, conn))
+	receive := func() int64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		notification, err := conn.WaitForNotification(ctx)
+		verify.Ok(t, err)
+		verify.Equals(t, channelVehicleStates, notification.Channel)
+		txID, err := strconv.ParseInt(notification.Payload, 10, 64)
+		verify.Ok(t, err)
+		return txID
+	}
+	committedAfter := func(cursor commitCursor) ([]int64, []commitCursor) {
+		states, err := getVehicleStatesCommittedAfter(logger, db, cursor, 0)
+		verify.Ok(t, err)
+		var ids []int64
+		var cursors []commitCursor
+		for _, state := range states {
+			ids = append(ids, state.ID)
+			cursors = append(cursors, state.Cursor)
+		}
+		return ids, cursors
+	}
+
+	t.Run("add", func(t *testing.T) {
+		// arrange
+		last, err := getLastCommitCursor(logger, db)
+		verify.Ok(t, err)
+		// action
+		id, err := addVehicleState(logger, db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()})
+		verify.Ok(t, err)
+		// verify
+		txID := receive()
+		ids, cursors := committedAfter(last)
+		verify.Equals(t, []int64{id}, ids)
+		verify.Equals(t, []commitCursor{{TxID: txID, Index: 1}}, cursors)
+		last, err = getLastCommitCursor(logger, db)
+		verify.Ok(t, err)
+		verify.Equals(t, cursors[0], last)
+	})
+
+	t.Run("add batch", func(t *testing.T) {
+		// arrange
+		last, err := getLastCommitCursor(logger, db)
+		verify.Ok(t, err)
+		states := make([]vehicleState, 3)
+		for i := range states {
+			states[i] = vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()}
+		}
+		// action
+		ids, err := addVehicleStates(logger, db, states)
+		verify.Ok(t, err)
+		// verify
+		txID := receive()
+		committed, cursors := committedAfter(last)
+		verify.Equals(t, ids, committed)
+		verify.Equals(t, []commitCursor{{TxID: txID, Index: 1}, {TxID: txID, Index: 2}, {TxID: txID, Index: 3}}, cursors)
+		resumed, _ := committedAfter(cursors[0])
+		verify.Equals(t, ids[1:], resumed)
+	})
+
+	t.Run("rolled back states are not announced", func(t *testing.T) {
+		// arrange
+		last, err := getLastCommitCursor(logger, db)
+		verify.Ok(t, err)
+		// action
+		_, err = addVehicleStates(logger, db, []vehicleState{
+			{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()},
+			{VehicleID: vehicleID + 1, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()},
+		})
+		verify.Equals(t, ErrorUnknownVehicle, err)
+		// verify
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		notification, err := conn.WaitForNotification(ctx)
+		verify.Assert(t, err != nil, "unexpected notification %v", notification)
+		ids, _ := committedAfter(last)
+		verify.Equals(t, 0, len(ids))
+	})
+
+	t.Run("states are ordered by commit", func(t *testing.T) {
+		// arrange
+		ctx := context.Background()
+		last, err := getLastCommitCursor(logger, db)
+		verify.Ok(t, err)
+		insert := func(tx pgx.Tx) int64 {
+			var id int64
+			err := tx.QueryRow(ctx, `INSERT INTO vehicle_state (vehicle_id, position, state_timestamp) VALUES ($1, 'POINT(20 30)', now()) RETURNING id`, vehicleID).Scan(&id)
+			verify.Ok(t, err)
+			return id
+		}
+		first, err := db.Begin(ctx)
+		verify.Ok(t, err)
+		defer first.Rollback(ctx)
+		firstID := insert(first)
+		second, err := db.Begin(ctx)
+		verify.Ok(t, err)
+		defer second.Rollback(ctx)
+		secondID := insert(second)
+		// action
+		verify.Ok(t, notifyVehicleStates(logger, second, []int64{secondID}))
+		verify.Ok(t, second.Commit(ctx))
+		receive()
+		pending, _ := committedAfter(last)
+		verify.Ok(t, notifyVehicleStates(logger, first, []int64{firstID}))
+		verify.Ok(t, first.Commit(ctx))
+		receive()
+		// verify
+		verify.Equals(t, 0, len(pending))
+		ids, _ := committedAfter(last)
+		verify.Equals(t, []int64{firstID, secondID}, ids)
+	})
+}
+
+func TestCommitCursor(t *testing.T) {
+	t.Run("format and parse", func(t *testing.T) {
+		// arrange
+		cursor := commitCursor{TxID: 1234, Index: 5}
+		// action
+		result, err := parseCommitCursor(cursor.String())
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, "1234.5", cursor.String())
+		verify.Equals(t, cursor, result)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, value := range []string{"", "12", "12.", ".3", "a.b", "1.2.3", "-1.2", "1.-2"} {
+			// action
+			_, err := parseCommitCursor(value)
+			// verify
+			verify.Assert(t, err != nil, "expected error for %q", value)
+		}
+	})
+
+	t.Run("order", func(t *testing.T) {
+		// verify
+		verify.Assert(t, commitCursor{TxID: 1, Index: 9}.before(commitCursor{TxID: 2, Index: 1}), "tx id takes precedence")
+		verify.Assert(t, commitCursor{TxID: 2, Index: 1}.before(commitCursor{TxID: 2, Index: 2}), "index orders states of a tx")
+		verify.Assert(t, !commitCursor{TxID: 2, Index: 2}.before(commitCursor{TxID: 2, Index: 2}), "cursor precedes itself")
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const tableReplaySession = "replay_sessions"
+
+// channelReplaySessions is the notification channel the ids of replay sessions are announced on
+// when their clock was changed, see updateReplaySession.
+const channelReplaySessions = "replay_sessions"
+
+// replaySessionTTL is the time after which a replay session whose stream stopped refreshing it is
+// considered closed, e.g. because its instance was stopped.
+const replaySessionTTL = time.Minute
+
+const replayClockColumns = "position, wall_time, speed, paused, seeks"
+
+func createTableReplaySession(logger *log.Logger, db *pgxpool.Pool) error {
+	logger.Printf("Creating table %s\n", tableReplaySession)
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s
+			(
+				id        varchar PRIMARY KEY,
+				position  TIMESTAMP NOT NULL,
+				wall_time TIMESTAMP NOT NULL,
+				speed     double precision NOT NULL,
+				paused    boolean NOT NULL,
+				seeks     integer NOT NULL,
+				expires   TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+			tableReplaySession,
+		),
+	)
+	return err
+}
+
+// addReplaySession stores the clock of a new replay session, which expires after replaySessionTTL
+// unless refreshed, see refreshReplaySession. Expired sessions are removed.
+func addReplaySession(logger *log.Logger, db *pgxpool.Pool, id string, clock replayClock) error {
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(`DELETE FROM %s WHERE expires < now()`, tableReplaySession),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`INSERT INTO %s (id, %s, expires) VALUES ($1, $2, $3, $4, $5, $6, now() + $7 * interval '1 second')`,
+			tableReplaySession,
+			replayClockColumns,
+		),
+		id,
+		clock.Position.UTC(),
+		clock.WallTime.UTC(),
+		clock.Speed,
+		clock.Paused,
+		clock.Seeks,
+		replaySessionTTL.Seconds(),
+	)
+	return err
+}
+
+// refreshReplaySession postpones the expiry of an open replay session.
+func refreshReplaySession(logger *log.Logger, db *pgxpool.Pool, id string) error {
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(`UPDATE %s SET expires = now() + $2 * interval '1 second' WHERE id = $1`, tableReplaySession),
+		id,
+		replaySessionTTL.Seconds(),
+	)
+	return err
+}
+
+func deleteReplaySession(logger *log.Logger, db *pgxpool.Pool, id string) error {
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, tableReplaySession),
+		id,
+	)
+	return err
+}
+
+// getReplaySession returns the clock of an open replay session.
+// If no session exists or it has expired, ErrorNotFound is returned.
+func getReplaySession(logger *log.Logger, db *pgxpool.Pool, id string) (replayClock, error) {
+	clock, err := scanReplayClock(db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT %s FROM %s WHERE id = $1 AND expires >= now()`,
+			replayClockColumns,
+			tableReplaySession,
+		),
+		id,
+	))
+	if err == pgx.ErrNoRows {
+		return clock, ErrorNotFound
+	}
+	return clock, err
+}
+
+// updateReplaySession applies the control to the clock of an open replay session at the given wall clock time
+// and announces the session on channelReplaySessions, so that the instance serving its stream reloads it.
+// If no session exists or it has expired, ErrorNotFound is returned.
+func updateReplaySession(logger *log.Logger, db *pgxpool.Pool, id string, ctl replayControl, now time.Time) (replayClock, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return replayClock{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	clock, err := scanReplayClock(tx.QueryRow(
+		ctx,
+		fmt.Sprintf(
+			`SELECT %s FROM %s WHERE id = $1 AND expires >= now() FOR UPDATE`,
+			replayClockColumns,
+			tableReplaySession,
+		),
+		id,
+	))
+	if err == pgx.ErrNoRows {
+		return clock, ErrorNotFound
+	}
+	if err != nil {
+		return clock, err
+	}
+	clock = clock.control(ctl, now)
+	_, err = tx.Exec(
+		ctx,
+		fmt.Sprintf(
+			`UPDATE %s SET position = $2, wall_time = $3, speed = $4, paused = $5, seeks = $6 WHERE id = $1`,
+			tableReplaySession,
+		),
+		id,
+		clock.Position.UTC(),
+		clock.WallTime.UTC(),
+		clock.Speed,
+		clock.Paused,
+		clock.Seeks,
+	)
+	if err != nil {
+		return clock, err
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, channelReplaySessions, id)
+	if err != nil {
+		return clock, err
+	}
+	return clock, tx.Commit(ctx)
+}
+
+// listenReplaySessions subscribes conn to channelReplaySessions, see updateReplaySession.
+func listenReplaySessions(logger *log.Logger, conn *pgx.Conn) error {
+	_, err := conn.Exec(context.Background(), "LISTEN "+channelReplaySessions)
+	return err
+}
+
+func scanReplayClock(row pgx.Row) (replayClock, error) {
+	var clock replayClock
+	err := row.Scan(&clock.Position, &clock.WallTime, &clock.Speed, &clock.Paused, &clock.Seeks)
+	return clock, err
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// mvtExtent is the number of tile units along each side of a vector tile.
+	mvtExtent = 4096
+	// mvtBuffer is the number of tile units geometries may extend beyond the tile.
+	mvtBuffer = 64
+	// mvtLayerVehicleStates is the name of the layer holding vehicle states.
+	mvtLayerVehicleStates = "vehicleStates"
+)
+
+// getVehicleStateTileVersion returns the number and the highest id of the vehicle states inside of tile t
+// matching filter. Both change whenever a state of the tile is added or deleted, so they identify the
+// content of the tile at a fraction of the cost of generating it. maxID is zero for empty tiles.
+func getVehicleStateTileVersion(logger *log.Logger, db *pgxpool.Pool, t tile, filter vehicleStateFilter) (int64, int64, error) {
+	bound := t.bound()
+	filter.BBox = &bound
+	where, args := filter.where()
+	var count, maxID int64
+	err := db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT count(*), COALESCE(max(id), 0) FROM %s %s`,
+			tableVehicleState,
+			where,
+		),
+		args...,
+	).Scan(&count, &maxID)
+	return count, maxID, err
+}
+
+// getVehicleStateTile returns the vehicle states inside of tile t matching filter as Mapbox vector tile.
+// Each feature carries id, vehicleId, timestamp (unix seconds), speed, heading and count.
+// If cellSize (in tile units) is positive, only the newest state of each grid cell is kept and
+// count holds the number of states in the cell. An empty tile is returned as empty slice.
+func getVehicleStateTile(logger *log.Logger, db *pgxpool.Pool, t tile, filter vehicleStateFilter, cellSize int) ([]byte, error) {
+	bound := t.bound()
+	filter.BBox = &bound
+	conditions, args := filter.conditions(nil)
+	m := t.mercatorBound()
+	args = append(args, m.Min.X(), m.Min.Y(), m.Max.X(), m.Max.Y())
+	envelope := fmt.Sprintf("ST_MakeEnvelope($%d, $%d, $%d, $%d, 3857)", len(args)-3, len(args)-2, len(args)-1, len(args))
+
+	states := fmt.Sprintf(
+		`SELECT
+			ST_AsMVTGeom(ST_Transform(position::geometry, 3857), %s, %d, %d, true) AS geom,
+			id, vehicle_id AS "vehicleId", extract(epoch FROM state_timestamp)::bigint AS "timestamp", speed, heading
+		FROM %s %s`,
+		envelope,
+		mvtExtent,
+		mvtBuffer,
+		tableVehicleState,
+		whereClause(conditions),
+	)
+	var features string
+	if cellSize > 0 {
+		args = append(args, cellSize)
+		features = fmt.Sprintf(
+			`SELECT DISTINCT ON (cx, cy) geom, id, "vehicleId", "timestamp", speed, heading, count(*) OVER (PARTITION BY cx, cy) AS count
+			FROM (
+				SELECT *, floor(ST_X(geom) / $%[1]d)::int AS cx, floor(ST_Y(geom) / $%[1]d)::int AS cy
+				FROM (%[2]s) AS states WHERE geom IS NOT NULL
+			) AS cells
+			ORDER BY cx, cy, "timestamp" DESC, id DESC`,
+			len(args),
+			states,
+		)
+	} else {
+		features = fmt.Sprintf(
+			`SELECT geom, id, "vehicleId", "timestamp", speed, heading, 1 AS count
+			FROM (%s) AS states WHERE geom IS NOT NULL`,
+			states,
+		)
+	}
+
+	var data []byte
+	err := db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT ST_AsMVT(features, '%s', %d, 'geom') FROM (%s) AS features`,
+			mvtLayerVehicleStates,
+			mvtExtent,
+			features,
+		),
+		args...,
+	).Scan(&data)
+	return data, err
+}
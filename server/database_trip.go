@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const tableTrip = "trips"
+
+const tripColumns = `id, vehicle_id, trip_type, start_time, end_time,
+	ST_AsBinary(start_position), ST_AsBinary(end_position), ST_AsBinary(path), distance`
+
+func createTableTrip(logger *log.Logger, db *pgxpool.Pool) error {
+	logger.Printf("Creating table %s\n", tableTrip)
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s
+			(
+				id              bigserial PRIMARY KEY,
+				vehicle_id      bigint NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+				trip_type       varchar NOT NULL CHECK (trip_type IN ('%s', '%s')),
+				start_time      TIMESTAMP NOT NULL,
+				end_time        TIMESTAMP NOT NULL,
+				start_position  GEOGRAPHY(POINT,4326) NOT NULL,
+				end_position    GEOGRAPHY(POINT,4326) NOT NULL,
+				path            GEOGRAPHY(GEOMETRY,4326) NOT NULL,
+				distance        double precision NOT NULL,
+				last_tx_id      bigint NOT NULL
+			)`,
+			tableTrip,
+			tableVehicle,
+			tripTypeTrip,
+			tripTypeStop,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_vehicle_id_start_time_idx ON %[1]s (vehicle_id, start_time)`,
+			tableTrip,
+		),
+	)
+	return err
+}
+
+// updateTrips segments the states of a vehicle into trips and stops that were committed since the last update.
+// last_tx_id records the commitHorizon of the update, below which all states are included, so the next update
+// resumes at the last stop before the earliest state committed since and replaces all segments from there on.
+// Without trips, all states are segmented, including those stored before commits were recorded.
+// Deleted states are not taken into account.
+func updateTrips(logger *log.Logger, db *pgxpool.Pool, vehicleID int64, config TripConfig) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockVehicles(tx, []int64{vehicleID})
+	if err != nil {
+		return err
+	}
+
+	// find earliest new state
+	var lastTxID *int64
+	err = tx.QueryRow(
+		ctx,
+		fmt.Sprintf(`SELECT max(last_tx_id) FROM %s WHERE vehicle_id = $1`, tableTrip),
+		vehicleID,
+	).Scan(&lastTxID)
+	if err != nil {
+		return err
+	}
+	var since *time.Time
+	var horizon int64
+	if lastTxID == nil {
+		err = tx.QueryRow(
+			ctx,
+			fmt.Sprintf(
+				`SELECT min(state_timestamp), %s FROM %s WHERE vehicle_id = $1`,
+				commitHorizon,
+				tableVehicleState,
+			),
+			vehicleID,
+		).Scan(&since, &horizon)
+	} else {
+		err = tx.QueryRow(
+			ctx,
+			fmt.Sprintf(
+				`SELECT min(s.state_timestamp), %[1]s FROM %[2]s c JOIN %[3]s s ON s.id = ANY(c.state_ids)
+				WHERE s.vehicle_id = $1 AND c.tx_id > $2 AND c.tx_id < %[1]s`,
+				commitHorizon,
+				tableVehicleStateCommit,
+				tableVehicleState,
+			),
+			vehicleID,
+			*lastTxID,
+		).Scan(&since, &horizon)
+	}
+	if err != nil || since == nil {
+		return err
+	}
+
+	// resume at the last stop before, if any
+	var stopStart *time.Time
+	err = tx.QueryRow(
+		ctx,
+		fmt.Sprintf(
+			`SELECT max(start_time) FROM %s WHERE vehicle_id = $1 AND trip_type = '%s' AND start_time <= $2`,
+			tableTrip,
+			tripTypeStop,
+		),
+		vehicleID,
+		since.UTC(),
+	).Scan(&stopStart)
+	if err != nil {
+		return err
+	}
+	filter := vehicleStateFilter{VehicleID: vehicleID, From: stopStart}
+	if stopStart != nil {
+		_, err = tx.Exec(
+			ctx,
+			fmt.Sprintf(`DELETE FROM %s WHERE vehicle_id = $1 AND start_time >= $2`, tableTrip),
+			vehicleID,
+			stopStart.UTC(),
+		)
+	} else {
+		_, err = tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE vehicle_id = $1`, tableTrip), vehicleID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// segment states from there on
+	var states []vehicleState
+	where, args := filter.where()
+	rows, err := tx.Query(
+		ctx,
+		fmt.Sprintf(`SELECT %s FROM %s %s %s`, vehicleStateColumns, tableVehicleState, where, filter.orderBy()),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		state, err := scanVehicleState(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		states = append(states, state)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for _, segment := range segmentTrips(vehicleID, states, config) {
+		_, err = tx.Exec(
+			ctx,
+			fmt.Sprintf(
+				`INSERT INTO %s (vehicle_id, trip_type, start_time, end_time, start_position, end_position, path, distance, last_tx_id)
+				VALUES ($1, $2, $3, $4, ST_GeomFromWKB($5, 4326), ST_GeomFromWKB($6, 4326), ST_GeomFromWKB($7, 4326), $8, $9)`,
+				tableTrip,
+			),
+			segment.VehicleID,
+			segment.Type,
+			segment.StartTime.UTC(),
+			segment.EndTime.UTC(),
+			wkb.Value(segment.StartPosition.Geometry()),
+			wkb.Value(segment.EndPosition.Geometry()),
+			wkb.Value(segment.Path.Geometry()),
+			segment.Distance,
+			horizon-1,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// tripFilter restricts the segments returned by getTrips.
+// From and To select segments overlapping the time range.
+type tripFilter struct {
+	VehicleID int64
+	Type      string
+	From, To  *time.Time
+}
+
+// getTrips returns the trips and stops of a vehicle, ordered by their start time.
+func getTrips(logger *log.Logger, db *pgxpool.Pool, filter tripFilter) ([]trip, error) {
+	conditions := []string{"vehicle_id = $1"}
+	args := []interface{}{filter.VehicleID}
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		conditions = append(conditions, fmt.Sprintf("trip_type = $%d", len(args)))
+	}
+	if filter.From != nil {
+		args = append(args, filter.From.UTC())
+		conditions = append(conditions, fmt.Sprintf("end_time >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, filter.To.UTC())
+		conditions = append(conditions, fmt.Sprintf("start_time < $%d", len(args)))
+	}
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT %s FROM %s %s ORDER BY start_time, id`,
+			tripColumns,
+			tableTrip,
+			whereClause(conditions),
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// collect result
+	trips := make([]trip, 0)
+	for rows.Next() {
+		t, err := scanTrip(rows)
+		if err != nil {
+			return trips, err
+		}
+		trips = append(trips, t)
+	}
+	return trips, rows.Err()
+}
+
+func scanTrip(row pgx.Row) (trip, error) {
+	var t trip
+	var start, end orb.Point
+	path := wkb.Scanner(nil)
+	err := row.Scan(&t.ID, &t.VehicleID, &t.Type, &t.StartTime, &t.EndTime, wkb.Scanner(&start), wkb.Scanner(&end), path, &t.Distance)
+	t.StartPosition = *geojson.NewGeometry(start)
+	t.EndPosition = *geojson.NewGeometry(end)
+	t.Path = *geojson.NewGeometry(path.Geometry)
+	t.Duration = t.EndTime.Sub(t.StartTime).Seconds()
+	return t, err
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+)
+
+func TestTripSchemaIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+	verify.Ok(t, createTableVehicle(logger, db))
+	verify.Ok(t, createTableVehicleState(logger, db))
+	verify.Ok(t, createTableVehicleStateCommit(logger, db))
+	verify.Ok(t, createTableGeofence(logger, db))
+	verify.Ok(t, createTableGeofenceEvent(logger, db))
+
+	t.Run("creating table", func(t *testing.T) {
+		// action
+		err := createTableTrip(logger, db)
+		// verify
+		verify.Ok(t, err)
+	})
+
+	vehicleID, _ := addVehicle(logger, db, vehicle{Name: "truck"})
+	states := tripTestStates(false, false, false, false, false, false, true, true, true, false, false, false, false, false, false)
+	for i := range states {
+		states[i].VehicleID = vehicleID
+	}
+
+	t.Run("update", func(t *testing.T) {
+		// arrange
+		_, err := addVehicleStates(logger, db, states[:8])
+		verify.Ok(t, err)
+		// action
+		err = updateTrips(logger, db, vehicleID, DefaultTripConfig)
+		verify.Ok(t, err)
+		result, err := getTrips(logger, db, tripFilter{VehicleID: vehicleID})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result))
+		verify.Equals(t, tripTypeTrip, result[1].Type)
+		verify.Equals(t, states[7].Timestamp, result[1].EndTime)
+	})
+
+	t.Run("update with new states", func(t *testing.T) {
+		// arrange
+		_, err := addVehicleStates(logger, db, states[8:])
+		verify.Ok(t, err)
+		// action
+		err = updateTrips(logger, db, vehicleID, DefaultTripConfig)
+		verify.Ok(t, err)
+		result, err := getTrips(logger, db, tripFilter{VehicleID: vehicleID})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 3, len(result))
+		verify.Equals(t, states[9].Timestamp, result[1].EndTime)
+		verify.Equals(t, 5, len(result[1].Path.Geometry().(orb.LineString)))
+		verify.Assert(t, result[1].Distance > 1600 && result[1].Distance < 1700, "unexpected distance %v", result[1].Distance)
+		verify.Equals(t, 300.0, result[2].Duration)
+	})
+
+	t.Run("vehicles with new states", func(t *testing.T) {
+		// action
+		ids, txID, err := getVehiclesCommittedAfter(logger, db, 0)
+		verify.Ok(t, err)
+		none, last, err := getVehiclesCommittedAfter(logger, db, txID)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, []int64{vehicleID}, ids)
+		verify.Equals(t, 0, len(none))
+		verify.Equals(t, txID, last)
+	})
+
+	t.Run("get by type and time range", func(t *testing.T) {
+		// arrange
+		from := states[7].Timestamp
+		to := from.Add(time.Hour)
+		// action
+		result, err := getTrips(logger, db, tripFilter{VehicleID: vehicleID, Type: tripTypeStop, From: &from, To: &to})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result))
+		verify.Equals(t, states[9].Timestamp, result[0].StartTime)
+	})
+}
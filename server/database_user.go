@@ -97,3 +97,34 @@ func getUsers(logger *log.Logger, db *pgxpool.Pool) ([]user, error) {
 
 	return users, err
 }
+
+// forEachUser passes all users together with their id to fn while they are read
+// from the cursor, ordered by id. Iteration stops at the first error of fn.
+func forEachUser(logger *log.Logger, db *pgxpool.Pool, fn func(id int64, user user) error) error {
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id, username FROM %s ORDER BY id`,
+			tableUser,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var user user
+		err = rows.Scan(&id, &user.Name)
+		if err != nil {
+			return err
+		}
+		err = fn(id, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const tableVehicle = "vehicle"
+
+func createTableVehicle(logger *log.Logger, db *pgxpool.Pool) error {
+	logger.Printf("Creating table %s\n", tableVehicle)
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s
+			(
+				id   bigserial PRIMARY KEY,
+				name varchar NOT NULL
+			)`,
+			tableVehicle,
+		),
+	)
+	return err
+}
+
+func addVehicle(logger *log.Logger, db *pgxpool.Pool, vehicle vehicle) (int64, error) {
+	var id int64
+	err := db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`INSERT INTO %s (name) VALUES ($1) RETURNING id`,
+			tableVehicle,
+		),
+		vehicle.Name,
+	).Scan(&id)
+	return id, err
+}
+
+// updateVehicle replaces the vehicle that is associated with the given id.
+// If no vehicle exists, ErrorNotFound is returned.
+func updateVehicle(logger *log.Logger, db *pgxpool.Pool, id int64, vehicle vehicle) error {
+	tag, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`UPDATE %s SET name=$2 WHERE id=$1`,
+			tableVehicle,
+		),
+		id,
+		vehicle.Name,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = ErrorNotFound
+	}
+	return err
+}
+
+// deleteVehicle removes the vehicle and, by cascade, all of its states.
+func deleteVehicle(logger *log.Logger, db *pgxpool.Pool, id int64) error {
+	_, err := db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`DELETE FROM %s WHERE id=$1`,
+			tableVehicle,
+		),
+		id,
+	)
+	return err
+}
+
+// lockVehicles locks the given vehicles until the end of tx, serializing
+// concurrent ingestion of states for the same vehicle.
+func lockVehicles(tx pgx.Tx, ids []int64) error {
+	_, err := tx.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id FROM %s WHERE id = ANY($1) ORDER BY id FOR UPDATE`,
+			tableVehicle,
+		),
+		ids,
+	)
+	return err
+}
+
+// getExistingVehicleIDs returns which of the given vehicle ids exist.
+func getExistingVehicleIDs(logger *log.Logger, db *pgxpool.Pool, ids []int64) (map[int64]bool, error) {
+	existing := make(map[int64]bool)
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id FROM %s WHERE id = ANY($1)`,
+			tableVehicle,
+		),
+		ids,
+	)
+	if err != nil {
+		return existing, err
+	}
+	defer rows.Close()
+
+	// collect result
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return existing, err
+		}
+		existing[id] = true
+	}
+
+	return existing, rows.Err()
+}
+
+// getVehicle returns the vehicle that is associated with the given id.
+// If no vehicle exists, ErrorNotFound is returned.
+func getVehicle(logger *log.Logger, db *pgxpool.Pool, id int64) (vehicle, error) {
+	var vehicle vehicle
+	err := db.QueryRow(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id, name FROM %s WHERE id=$1`,
+			tableVehicle,
+		),
+		id,
+	).Scan(&vehicle.ID, &vehicle.Name)
+	if err == pgx.ErrNoRows {
+		err = ErrorNotFound // return custom error
+	}
+	return vehicle, err
+}
+
+func getVehicles(logger *log.Logger, db *pgxpool.Pool) ([]vehicle, error) {
+	var vehicles []vehicle
+	// query all rows
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT id, name FROM %s ORDER BY id`,
+			tableVehicle,
+		),
+	)
+	if err != nil {
+		return vehicles, err
+	}
+	defer rows.Close()
+
+	// collect result
+	for rows.Next() {
+		var vehicle vehicle
+		err = rows.Scan(&vehicle.ID, &vehicle.Name)
+		if err != nil {
+			return vehicles, err
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+
+	return vehicles, rows.Err()
+}
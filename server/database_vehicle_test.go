@@ -0,0 +1,99 @@
+package server
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestVehicleSchemaIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+
+	var err error
+	t.Run("creating table", func(t *testing.T) {
+		// action
+		err = createTableVehicle(logger, db)
+		verify.Ok(t, err)
+		err = createTableVehicleState(logger, db)
+		verify.Ok(t, err)
+		err = createTableVehicleStateCommit(logger, db)
+		verify.Ok(t, err)
+		err = createTableGeofence(logger, db)
+		verify.Ok(t, err)
+		err = createTableGeofenceEvent(logger, db)
+		// verify
+		verify.Ok(t, err)
+	})
+
+	var id int64
+	t.Run("adding vehicle", func(t *testing.T) {
+		// action
+		id, err = addVehicle(logger, db, vehicle{Name: "truck"})
+		// verify
+		verify.Ok(t, err)
+		verify.Assert(t, id > 0, "no id returned")
+	})
+
+	t.Run("updating vehicle", func(t *testing.T) {
+		// action
+		err := updateVehicle(logger, db, id, vehicle{Name: "truck 1"})
+		// verify
+		verify.Ok(t, err)
+	})
+
+	t.Run("updating unknown vehicle", func(t *testing.T) {
+		// action
+		err := updateVehicle(logger, db, id+1, vehicle{Name: "truck 2"})
+		// verify
+		verify.Equals(t, ErrorNotFound, err)
+	})
+
+	t.Run("getting vehicle by id", func(t *testing.T) {
+		// action
+		result, err := getVehicle(logger, db, id)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, vehicle{ID: id, Name: "truck 1"}, result)
+	})
+
+	t.Run("getting all vehicles", func(t *testing.T) {
+		// action
+		result, err := getVehicles(logger, db)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result))
+		verify.Equals(t, "truck 1", result[0].Name)
+	})
+
+	t.Run("delete vehicle by id, should delete its states", func(t *testing.T) {
+		// arrange
+		stateID, err := addVehicleState(logger, db, vehicleState{VehicleID: id, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()})
+		verify.Ok(t, err)
+		// action
+		err = deleteVehicle(logger, db, id)
+		// verify
+		verify.Ok(t, err)
+		_, err = getVehicleState(logger, db, stateID)
+		verify.Equals(t, ErrorNotFound, err)
+	})
+
+	t.Run("getting vehicle by id, should return not found", func(t *testing.T) {
+		// action
+		_, err := getVehicle(logger, db, id)
+		// verify
+		verify.Equals(t, ErrorNotFound, err)
+	})
+}
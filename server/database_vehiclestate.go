@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v4"
@@ -15,6 +16,10 @@ import (
 
 const tableVehicleState = "vehicle_state"
 
+// vehicleStateColumns lists the columns read by scanVehicleState, in order.
+const vehicleStateColumns = "id, vehicle_id, ST_AsBinary(position), state_timestamp, " +
+	"speed, heading, altitude, accuracy, odometer, ignition, attributes"
+
 func createTableVehicleState(logger *log.Logger, db *pgxpool.Pool) error {
 	logger.Printf("Creating table %s\n", tableVehicleState)
 	_, err := db.Exec(
@@ -22,28 +27,414 @@ func createTableVehicleState(logger *log.Logger, db *pgxpool.Pool) error {
 		fmt.Sprintf(
 			`CREATE TABLE IF NOT EXISTS %s
 			(
-				id              bigserial,
+				id              bigserial PRIMARY KEY,
+				vehicle_id      bigint NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
 				position        GEOGRAPHY(POINT, 4326) NOT NULL,
-				state_timestamp TIMESTAMP
+				state_timestamp TIMESTAMP,
+				speed           double precision,
+				heading         double precision,
+				altitude        double precision,
+				accuracy        double precision,
+				odometer        double precision,
+				ignition        boolean,
+				attributes      jsonb
 			)`,
 			tableVehicleState,
+			tableVehicle,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	err = upgradeTableVehicleState(logger, db)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_vehicle_id_idx ON %[1]s (vehicle_id, state_timestamp)`,
+			tableVehicleState,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	// used for distance searches
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_position_idx ON %[1]s USING GIST (position)`,
+			tableVehicleState,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	// bounding box filters compare lon/lat planar, see vehicleStateFilter
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_position_geom_idx ON %[1]s USING GIST ((position::geometry))`,
+			tableVehicleState,
+		),
+	)
+	if err != nil {
+		return err
+	}
+	// used for attribute containment filters
+	_, err = db.Exec(
+		context.Background(),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %[1]s_attributes_idx ON %[1]s USING GIN (attributes jsonb_path_ops)`,
+			tableVehicleState,
 		),
 	)
 	return err
 }
 
-func addVehicleState(logger *log.Logger, db *pgxpool.Pool, position orb.Point, timestamp time.Time) (int64, error) {
-	_, err := db.Exec(
-		context.Background(),
+// placeholderVehicleName is the name of the vehicle that states stored before vehicles were
+// introduced are assigned to, see upgradeTableVehicleState.
+const placeholderVehicleName = "unassigned"
+
+// upgradeTableVehicleState adds the columns and constraints missing in a table created by an earlier version,
+// e.g. one holding only id, position and state_timestamp. Existing states are assigned to a placeholder vehicle,
+// their telemetry is left empty.
+// The table is locked while upgrading, so that instances starting at the same time upgrade it only once.
+func upgradeTableVehicleState(logger *log.Logger, db *pgxpool.Pool) error {
+	ctx := context.Background()
+	columns := []string{"vehicle_id", "speed", "heading", "altitude", "accuracy", "odometer", "ignition", "attributes"}
+	var missing bool
+	err := db.QueryRow(
+		ctx,
+		`SELECT count(*) < $3 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = ANY($2)`,
+		tableVehicleState,
+		columns,
+		len(columns),
+	).Scan(&missing)
+	if err != nil || !missing {
+		return err
+	}
+	logger.Printf("Upgrading table %s\n", tableVehicleState)
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	statements := []string{
+		fmt.Sprintf(`LOCK TABLE %s`, tableVehicleState),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS vehicle_id bigint`, tableVehicleState),
+		fmt.Sprintf(
+			`WITH placeholder AS (
+				INSERT INTO %[2]s (name) SELECT '%[3]s' WHERE EXISTS (SELECT 1 FROM %[1]s WHERE vehicle_id IS NULL)
+				RETURNING id
+			)
+			UPDATE %[1]s SET vehicle_id = (SELECT id FROM placeholder) WHERE vehicle_id IS NULL`,
+			tableVehicleState,
+			tableVehicle,
+			placeholderVehicleName,
+		),
+		fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN vehicle_id SET NOT NULL`, tableVehicleState),
+		fmt.Sprintf(
+			`ALTER TABLE %s
+				ADD COLUMN IF NOT EXISTS speed double precision,
+				ADD COLUMN IF NOT EXISTS heading double precision,
+				ADD COLUMN IF NOT EXISTS altitude double precision,
+				ADD COLUMN IF NOT EXISTS accuracy double precision,
+				ADD COLUMN IF NOT EXISTS odometer double precision,
+				ADD COLUMN IF NOT EXISTS ignition boolean,
+				ADD COLUMN IF NOT EXISTS attributes jsonb`,
+			tableVehicleState,
+		),
+	}
+	for _, sql := range statements {
+		_, err = tx.Exec(ctx, sql)
+		if err != nil {
+			return err
+		}
+	}
+
+	// constraints are named like those of a new table
+	constraints := []struct{ name, definition string }{
+		{tableVehicleState + "_pkey", "PRIMARY KEY (id)"},
+		{tableVehicleState + "_vehicle_id_fkey", fmt.Sprintf("FOREIGN KEY (vehicle_id) REFERENCES %s (id) ON DELETE CASCADE", tableVehicle)},
+	}
+	for _, constraint := range constraints {
+		var exists bool
+		err = tx.QueryRow(
+			ctx,
+			`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = $1::regclass AND conname = $2)`,
+			tableVehicleState,
+			constraint.name,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			_, err = tx.Exec(
+				ctx,
+				fmt.Sprintf(`ALTER TABLE %s ADD CONSTRAINT %s %s`, tableVehicleState, constraint.name, constraint.definition),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// vehicleStateFilter restricts the states returned by getVehicleStates.
+// Zero values do not restrict the result.
+type vehicleStateFilter struct {
+	VehicleID int64
+	// BBox selects states inside a lon/lat box. The box is compared planar
+	// (not along great circles), matching what a map viewport shows.
+	BBox *orb.Bound
+	// From selects states at or after the given time.
+	From *time.Time
+	// To selects states before the given time.
+	To *time.Time
+	// Attributes selects states whose attributes contain the given values.
+	Attributes map[string]interface{}
+	// Descending returns the newest state first.
+	Descending bool
+}
+
+// where returns the WHERE clause of the filter together with its arguments.
+func (f vehicleStateFilter) where() (string, []interface{}) {
+	conditions, args := f.conditions(nil)
+	return whereClause(conditions), args
+}
+
+// conditions returns the SQL conditions of the filter. Arguments are appended
+// to args and referenced by their resulting position.
+func (f vehicleStateFilter) conditions(args []interface{}) ([]string, []interface{}) {
+	var conditions []string
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.VehicleID != 0 {
+		conditions = append(conditions, "vehicle_id = "+arg(f.VehicleID))
+	}
+	if f.BBox != nil {
+		var envelopes []string
+		for _, b := range splitAtAntimeridian(*f.BBox) {
+			envelopes = append(envelopes, fmt.Sprintf(
+				"position::geometry && ST_MakeEnvelope(%s, %s, %s, %s, 4326)",
+				arg(b.Min.Lon()), arg(b.Min.Lat()), arg(b.Max.Lon()), arg(b.Max.Lat()),
+			))
+		}
+		conditions = append(conditions, "("+strings.Join(envelopes, " OR ")+")")
+	}
+	if f.From != nil {
+		conditions = append(conditions, "state_timestamp >= "+arg(f.From.UTC()))
+	}
+	if f.To != nil {
+		conditions = append(conditions, "state_timestamp < "+arg(f.To.UTC()))
+	}
+	if len(f.Attributes) > 0 {
+		conditions = append(conditions, "attributes @> "+arg(f.Attributes))
+	}
+	return conditions, args
+}
+
+// orderBy returns the ORDER BY clause of the filter.
+func (f vehicleStateFilter) orderBy() string {
+	if f.Descending {
+		return "ORDER BY state_timestamp DESC, id DESC"
+	}
+	return "ORDER BY state_timestamp, id"
+}
+
+// whereClause joins the given conditions into a WHERE clause.
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// addVehicleState stores a new state and returns its id.
+// Geofence transitions caused by the new state are stored along with it, see detectGeofenceEvents.
+// Listeners are notified of the state once it is committed, see notifyVehicleStates.
+// If the vehicle does not exist, ErrorUnknownVehicle is returned.
+func addVehicleState(logger *log.Logger, db *pgxpool.Pool, state vehicleState) (int64, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockVehicles(tx, []int64{state.VehicleID})
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow(
+		ctx,
+		fmt.Sprintf(
+			`INSERT INTO %s (vehicle_id, position, state_timestamp, speed, heading, altitude, accuracy, odometer, ignition, attributes)
+			VALUES ($1, ST_GeomFromWKB($2), $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+			tableVehicleState,
+		),
+		vehicleStateValues(state)...,
+	).Scan(&id)
+	if isForeignKeyViolation(err) {
+		return 0, ErrorUnknownVehicle
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = detectGeofenceEvents(logger, tx, []int64{id})
+	if err != nil {
+		return 0, err
+	}
+	err = notifyVehicleStates(logger, tx, []int64{id})
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit(ctx)
+}
+
+// addVehicleStates stores the given states in a single transaction using COPY and returns
+// their ids in the same order. Geofence transitions are detected as for addVehicleState.
+// If one of the vehicles does not exist, ErrorUnknownVehicle is returned and nothing is stored.
+func addVehicleStates(logger *log.Logger, db *pgxpool.Pool, states []vehicleState) ([]int64, error) {
+	if len(states) == 0 {
+		return nil, nil
+	}
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	vehicleIDs := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, state := range states {
+		if !seen[state.VehicleID] {
+			seen[state.VehicleID] = true
+			vehicleIDs = append(vehicleIDs, state.VehicleID)
+		}
+	}
+	err = lockVehicles(tx, vehicleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// COPY cannot return generated ids, so they are reserved in advance
+	ids := make([]int64, 0, len(states))
+	rows, err := tx.Query(
+		ctx,
+		`SELECT nextval(pg_get_serial_sequence($1, 'id')) FROM generate_series(1, $2)`,
+		tableVehicleState,
+		len(states),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// COPY into a staging table, because the position has to be converted from WKB
+	importTable := tableVehicleState + "_import"
+	_, err = tx.Exec(
+		ctx,
+		fmt.Sprintf(
+			`CREATE TEMP TABLE %s
+			(
+				id              bigint,
+				vehicle_id      bigint,
+				position        bytea,
+				state_timestamp TIMESTAMP,
+				speed           double precision,
+				heading         double precision,
+				altitude        double precision,
+				accuracy        double precision,
+				odometer        double precision,
+				ignition        boolean,
+				attributes      jsonb
+			) ON COMMIT DROP`,
+			importTable,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{importTable},
+		[]string{"id", "vehicle_id", "position", "state_timestamp", "speed", "heading", "altitude", "accuracy", "odometer", "ignition", "attributes"},
+		pgx.CopyFromSlice(len(states), func(i int) ([]interface{}, error) {
+			return append([]interface{}{ids[i]}, vehicleStateValues(states[i])...), nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		ctx,
 		fmt.Sprintf(
-			"INSERT INTO %s (position, state_timestamp) VALUES (ST_GeomFromWKB($1), $2)",
+			`INSERT INTO %s (id, vehicle_id, position, state_timestamp, speed, heading, altitude, accuracy, odometer, ignition, attributes)
+			SELECT id, vehicle_id, ST_GeomFromWKB(position), state_timestamp, speed, heading, altitude, accuracy, odometer, ignition, attributes
+			FROM %s`,
 			tableVehicleState,
+			importTable,
 		),
-		wkb.Value(position),
-		timestamp,
 	)
+	if isForeignKeyViolation(err) {
+		return nil, ErrorUnknownVehicle
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = detectGeofenceEvents(logger, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	err = notifyVehicleStates(logger, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit(ctx)
+}
 
-	return 1, err
+// vehicleStateValues returns the values to insert for the given state, in column order
+// vehicle_id, position (as WKB), state_timestamp, speed, heading, altitude, accuracy, odometer, ignition, attributes.
+func vehicleStateValues(state vehicleState) []interface{} {
+	var attributes interface{} // store SQL NULL rather than JSON null
+	if len(state.Attributes) > 0 {
+		attributes = state.Attributes
+	}
+	return []interface{}{
+		state.VehicleID,
+		wkb.Value(state.Position.Geometry()),
+		state.Timestamp.UTC(), // state_timestamp has no time zone and is stored as UTC
+		state.Speed,
+		state.Heading,
+		state.Altitude,
+		state.Accuracy,
+		state.Odometer,
+		state.Ignition,
+		attributes,
+	}
 }
 
 func deleteVehicleState(logger *log.Logger, db *pgxpool.Pool, id int64) error {
@@ -58,40 +449,101 @@ func deleteVehicleState(logger *log.Logger, db *pgxpool.Pool, id int64) error {
 	return err
 }
 
-// getVehicleState returns the position that is associated with the given id.
-// If no position exists, pgx.ErrNoRows is returned.
-func getVehicleState(logger *log.Logger, db *pgxpool.Pool, id int64) (vehicleState, error) {
+// scanVehicleState reads a single row selected with vehicleStateColumns.
+// Columns selected after vehicleStateColumns are scanned into extra.
+func scanVehicleState(row pgx.Row, extra ...interface{}) (vehicleState, error) {
+	var state vehicleState
 	var position orb.Point
-	var timestamp time.Time
-	var err error
+	dest := []interface{}{
+		&state.ID, &state.VehicleID, wkb.Scanner(&position), &state.Timestamp,
+		&state.Speed, &state.Heading, &state.Altitude, &state.Accuracy, &state.Odometer, &state.Ignition, &state.Attributes,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	state.Position = *geojson.NewGeometry(position)
+	return state, err
+}
 
-	err = db.QueryRow(
+// getVehicleState returns the position that is associated with the given id.
+// If no position exists, ErrorNotFound is returned.
+func getVehicleState(logger *log.Logger, db *pgxpool.Pool, id int64) (vehicleState, error) {
+	state, err := scanVehicleState(db.QueryRow(
 		context.Background(),
 		fmt.Sprintf(
-			`SELECT ST_AsBinary(position), state_timestamp FROM %s WHERE id=$1`,
+			`SELECT %s FROM %s WHERE id=$1`,
+			vehicleStateColumns,
 			tableVehicleState,
 		),
 		id,
-	).Scan(wkb.Scanner(&position), &timestamp)
+	))
 	if err == pgx.ErrNoRows {
 		err = ErrorNotFound // return custom error
 	}
-	return vehicleState{Position: *geojson.NewGeometry(position), Timestamp: timestamp}, err
+	return state, err
 }
 
-func getVehicleStates(logger *log.Logger, db *pgxpool.Pool) ([]vehicleState, error) {
-	var states []vehicleState
+// getVehicleStates returns all states matching the filter, ordered by their timestamp.
+func getVehicleStates(logger *log.Logger, db *pgxpool.Pool, filter vehicleStateFilter) ([]vehicleState, error) {
+	where, args := filter.where()
+	return queryVehicleStates(
+		db,
+		fmt.Sprintf(
+			`SELECT %s FROM %s %s %s`,
+			vehicleStateColumns,
+			tableVehicleState,
+			where,
+			filter.orderBy(),
+		),
+		args...,
+	)
+}
 
-	var position orb.Point
-	var timestamp time.Time
-	var err error
-	// query all rows
+// getLatestVehicleStates returns the newest state of each vehicle. If bbox is given,
+// only vehicles whose newest state is inside of it are returned.
+func getLatestVehicleStates(logger *log.Logger, db *pgxpool.Pool, bbox *orb.Bound) ([]vehicleState, error) {
+	where, args := vehicleStateFilter{BBox: bbox}.where()
+	return queryVehicleStates(
+		db,
+		fmt.Sprintf(
+			`SELECT %[1]s FROM (
+				SELECT DISTINCT ON (vehicle_id) * FROM %[2]s ORDER BY vehicle_id, state_timestamp DESC, id DESC
+			) AS latest %[3]s ORDER BY vehicle_id`,
+			vehicleStateColumns,
+			tableVehicleState,
+			where,
+		),
+		args...,
+	)
+}
+
+// getNearbyVehicleStates returns the states matching the filter ordered by their distance to center.
+// If radius is greater than zero, only states within radius metres are returned.
+// If k is greater than zero, at most k states are returned.
+func getNearbyVehicleStates(logger *log.Logger, db *pgxpool.Pool, filter vehicleStateFilter, center orb.Point, radius float64, k int) ([]nearbyVehicleState, error) {
+	conditions, args := filter.conditions(nil)
+	args = append(args, center.Lon(), center.Lat())
+	point := fmt.Sprintf("ST_SetSRID(ST_MakePoint($%d, $%d), 4326)::geography", len(args)-1, len(args))
+	if radius > 0 {
+		args = append(args, radius)
+		conditions = append(conditions, fmt.Sprintf("ST_DWithin(position, %s, $%d)", point, len(args)))
+	}
+	limit := ""
+	if k > 0 {
+		args = append(args, k)
+		limit = fmt.Sprintf("LIMIT $%d", len(args))
+	}
+
+	var states []nearbyVehicleState
 	rows, err := db.Query(
 		context.Background(),
 		fmt.Sprintf(
-			`SELECT ST_AsBinary(position), state_timestamp FROM %s`,
+			`SELECT %[1]s, ST_Distance(position, %[2]s) FROM %[3]s %[4]s ORDER BY position <-> %[2]s, id %[5]s`,
+			vehicleStateColumns,
+			point,
 			tableVehicleState,
+			whereClause(conditions),
+			limit,
 		),
+		args...,
 	)
 	if err != nil {
 		return states, err
@@ -100,12 +552,185 @@ func getVehicleStates(logger *log.Logger, db *pgxpool.Pool) ([]vehicleState, err
 
 	// collect result
 	for rows.Next() {
-		err = rows.Scan(wkb.Scanner(&position), &timestamp)
+		var state nearbyVehicleState
+		state.vehicleState, err = scanVehicleState(rows, &state.Distance)
 		if err != nil {
 			return states, err
 		}
-		states = append(states, vehicleState{Position: *geojson.NewGeometry(position), Timestamp: timestamp})
+		states = append(states, state)
+	}
+
+	return states, rows.Err()
+}
+
+// getVehicleStatesPage returns up to limit states matching filter in ascending order of their timestamp,
+// following the given state if not nil, so that long histories can be read page by page.
+func getVehicleStatesPage(logger *log.Logger, db *pgxpool.Pool, filter vehicleStateFilter, after *vehicleState, limit int) ([]vehicleState, error) {
+	filter.Descending = false
+	conditions, args := filter.conditions(nil)
+	if after != nil {
+		args = append(args, after.Timestamp.UTC(), after.ID)
+		conditions = append(conditions, fmt.Sprintf("(state_timestamp, id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, limit)
+	return queryVehicleStates(
+		db,
+		fmt.Sprintf(
+			`SELECT %s FROM %s %s %s LIMIT $%d`,
+			vehicleStateColumns,
+			tableVehicleState,
+			whereClause(conditions),
+			filter.orderBy(),
+			len(args),
+		),
+		args...,
+	)
+}
+
+// queryVehicleStates collects all rows returned by a query selecting vehicleStateColumns.
+func queryVehicleStates(db *pgxpool.Pool, sql string, args ...interface{}) ([]vehicleState, error) {
+	var states []vehicleState
+	err := eachVehicleState(db, sql, args, func(state vehicleState) error {
+		states = append(states, state)
+		return nil
+	})
+	return states, err
+}
+
+// forEachVehicleState is like getVehicleStates, but passes the states to fn while they
+// are read from the cursor instead of collecting them. Iteration stops at the first error of fn.
+func forEachVehicleState(logger *log.Logger, db *pgxpool.Pool, filter vehicleStateFilter, fn func(vehicleState) error) error {
+	where, args := filter.where()
+	return eachVehicleState(
+		db,
+		fmt.Sprintf(
+			`SELECT %s FROM %s %s %s`,
+			vehicleStateColumns,
+			tableVehicleState,
+			where,
+			filter.orderBy(),
+		),
+		args,
+		fn,
+	)
+}
+
+// forEachVehicleStateCommittedAfter calls fn for each state matching filter that was committed after cursor
+// and below commitHorizon, in commit order, with Cursor set. If limit is greater than zero, at most limit
+// states are passed. Deleted states are skipped.
+func forEachVehicleStateCommittedAfter(logger *log.Logger, db *pgxpool.Pool, filter subscriptionFilter, cursor commitCursor, limit int, fn func(vehicleState) error) error {
+	conditions, args := vehicleStateFilter{BBox: filter.BBox}.conditions([]interface{}{cursor.TxID, cursor.Index})
+	conditions = append([]string{"c.tx_id >= $1", "(c.tx_id, u.n) > ($1, $2)", "c.tx_id < " + commitHorizon}, conditions...)
+	if len(filter.VehicleIDs) > 0 {
+		args = append(args, filter.VehicleIDs)
+		conditions = append(conditions, fmt.Sprintf("vehicle_id = ANY($%d)", len(args)))
+	}
+	limitClause := ""
+	if limit > 0 {
+		args = append(args, limit)
+		limitClause = fmt.Sprintf("LIMIT $%d", len(args))
+	}
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT %s, c.tx_id, u.n FROM %s c
+			CROSS JOIN LATERAL unnest(c.state_ids) WITH ORDINALITY AS u(state_id, n)
+			JOIN %s ON id = u.state_id
+			%s ORDER BY c.tx_id, u.n %s`,
+			vehicleStateColumns,
+			tableVehicleStateCommit,
+			tableVehicleState,
+			whereClause(conditions),
+			limitClause,
+		),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cursor commitCursor
+		state, err := scanVehicleState(rows, &cursor.TxID, &cursor.Index)
+		if err != nil {
+			return err
+		}
+		state.Cursor = cursor
+		err = fn(state)
+		if err != nil {
+			return err
+		}
 	}
+	return rows.Err()
+}
 
+// getVehicleStatesCommittedAfter returns up to limit states committed after cursor, see forEachVehicleStateCommittedAfter.
+func getVehicleStatesCommittedAfter(logger *log.Logger, db *pgxpool.Pool, cursor commitCursor, limit int) ([]vehicleState, error) {
+	var states []vehicleState
+	err := forEachVehicleStateCommittedAfter(logger, db, subscriptionFilter{}, cursor, limit, func(state vehicleState) error {
+		states = append(states, state)
+		return nil
+	})
 	return states, err
 }
+
+// getVehiclesCommittedAfter returns the ids of the vehicles with states committed by transactions after afterTxID
+// and below commitHorizon, and the id of the last of these transactions. If there are none, afterTxID is returned.
+func getVehiclesCommittedAfter(logger *log.Logger, db *pgxpool.Pool, afterTxID int64) ([]int64, int64, error) {
+	rows, err := db.Query(
+		context.Background(),
+		fmt.Sprintf(
+			`SELECT s.vehicle_id, max(c.tx_id) FROM %s c JOIN %s s ON s.id = ANY(c.state_ids)
+			WHERE c.tx_id > $1 AND c.tx_id < %s
+			GROUP BY s.vehicle_id ORDER BY s.vehicle_id`,
+			tableVehicleStateCommit,
+			tableVehicleState,
+			commitHorizon,
+		),
+		afterTxID,
+	)
+	if err != nil {
+		return nil, afterTxID, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	lastTxID := afterTxID
+	for rows.Next() {
+		var id, txID int64
+		err := rows.Scan(&id, &txID)
+		if err != nil {
+			return nil, afterTxID, err
+		}
+		ids = append(ids, id)
+		if txID > lastTxID {
+			lastTxID = txID
+		}
+	}
+	if rows.Err() != nil {
+		return nil, afterTxID, rows.Err()
+	}
+	return ids, lastTxID, nil
+}
+
+func eachVehicleState(db *pgxpool.Pool, sql string, args []interface{}, fn func(vehicleState) error) error {
+	rows, err := db.Query(context.Background(), sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		state, err := scanVehicleState(rows)
+		if err != nil {
+			return err
+		}
+		err = fn(state)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
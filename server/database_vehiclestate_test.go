@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"log"
 	"os"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/EricNeid/go-webserver/internal/verify"
 	"github.com/jackc/pgx/v4"
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
 )
 
 func TestVehicleStateSchemaIntegration(t *testing.T) {
@@ -25,19 +27,32 @@ func TestVehicleStateSchemaIntegration(t *testing.T) {
 	var err error
 	t.Run("creating table", func(t *testing.T) {
 		// action
+		err = createTableVehicle(logger, db)
+		verify.Ok(t, err)
 		err = createTableVehicleState(logger, db)
+		verify.Ok(t, err)
+		err = createTableVehicleStateCommit(logger, db)
+		verify.Ok(t, err)
+		err = createTableGeofence(logger, db)
+		verify.Ok(t, err)
+		err = createTableGeofenceEvent(logger, db)
 		// verify
 		verify.Ok(t, err)
 	})
 
+	vehicleID, _ := addVehicle(logger, db, vehicle{Name: "truck"})
+
 	var id int64
 	t.Run("add", func(t *testing.T) {
 		// action
 		id, err = addVehicleState(
 			logger,
 			db,
-			orb.Point([2]float64{20, 30}),
-			time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC),
+			vehicleState{
+				VehicleID: vehicleID,
+				Position:  *geojson.NewGeometry(orb.Point([2]float64{20, 30})),
+				Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC),
+			},
 		)
 		// verify
 		verify.Ok(t, err)
@@ -49,6 +64,7 @@ func TestVehicleStateSchemaIntegration(t *testing.T) {
 		result, err := getVehicleState(logger, db, id)
 		// verify
 		verify.Ok(t, err)
+		verify.Equals(t, vehicleID, result.VehicleID)
 		p := result.Position.Geometry().(orb.Point)
 		verify.Condition(t, p.X()-20.0 < 0.1)
 		verify.Condition(t, p.Y()-30.0 < 0.1)
@@ -60,10 +76,110 @@ func TestVehicleStateSchemaIntegration(t *testing.T) {
 
 	t.Run("get all", func(t *testing.T) {
 		// action
-		result, err := getVehicleStates(logger, db)
+		result, err := getVehicleStates(logger, db, vehicleStateFilter{})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result))
+	})
+
+	t.Run("get all of vehicle", func(t *testing.T) {
+		// action
+		result, err := getVehicleStates(logger, db, vehicleStateFilter{VehicleID: vehicleID})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result))
+		verify.Equals(t, id, result[0].ID)
+	})
+
+	t.Run("get all inside bbox", func(t *testing.T) {
+		// action
+		inside, err := getVehicleStates(logger, db, vehicleStateFilter{BBox: &orb.Bound{Min: orb.Point{19, 29}, Max: orb.Point{21, 31}}})
+		verify.Ok(t, err)
+		outside, err := getVehicleStates(logger, db, vehicleStateFilter{BBox: &orb.Bound{Min: orb.Point{21, 29}, Max: orb.Point{19, 31}}})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(inside))
+		verify.Equals(t, 0, len(outside))
+	})
+
+	t.Run("get all in time range", func(t *testing.T) {
+		// arrange
+		from := time.Date(2021, 6, 15, 11, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		to := from.Add(time.Hour)
+		// action
+		inside, err := getVehicleStates(logger, db, vehicleStateFilter{From: &from, To: &to})
+		verify.Ok(t, err)
+		outside, err := getVehicleStates(logger, db, vehicleStateFilter{From: &to})
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(inside))
+		verify.Equals(t, 0, len(outside))
+	})
+
+	t.Run("get latest", func(t *testing.T) {
+		// arrange
+		newer, err := addVehicleState(logger, db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{21, 31}), Timestamp: time.Date(2021, 6, 15, 9, 5, 0, 0, time.UTC)})
+		verify.Ok(t, err)
+		defer deleteVehicleState(logger, db, newer)
+		// action
+		result, err := getLatestVehicleStates(logger, db, nil)
+		verify.Ok(t, err)
+		outside, err := getLatestVehicleStates(logger, db, &orb.Bound{Min: orb.Point{19, 29}, Max: orb.Point{20.5, 30.5}})
 		// verify
 		verify.Ok(t, err)
 		verify.Equals(t, 1, len(result))
+		verify.Equals(t, newer, result[0].ID)
+		verify.Equals(t, 0, len(outside))
+	})
+
+	t.Run("add with telemetry", func(t *testing.T) {
+		// arrange
+		speed, ignition := 12.5, true
+		state := vehicleState{
+			VehicleID:  vehicleID,
+			Position:   *geojson.NewGeometry(orb.Point{20, 30}),
+			Timestamp:  time.Date(2021, 6, 15, 8, 0, 0, 0, time.UTC),
+			Speed:      &speed,
+			Ignition:   &ignition,
+			Attributes: map[string]interface{}{"door": "open", "fuel": 0.5},
+		}
+		// action
+		telemetryID, err := addVehicleState(logger, db, state)
+		verify.Ok(t, err)
+		defer deleteVehicleState(logger, db, telemetryID)
+		// verify
+		result, err := getVehicleState(logger, db, telemetryID)
+		verify.Ok(t, err)
+		verify.Equals(t, speed, *result.Speed)
+		verify.Equals(t, ignition, *result.Ignition)
+		verify.Assert(t, result.Heading == nil, "heading is set")
+		verify.Equals(t, state.Attributes, result.Attributes)
+		filtered, err := getVehicleStates(logger, db, vehicleStateFilter{Attributes: map[string]interface{}{"door": "open"}})
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(filtered))
+		verify.Equals(t, telemetryID, filtered[0].ID)
+	})
+
+	t.Run("get nearby", func(t *testing.T) {
+		// action
+		within, err := getNearbyVehicleStates(logger, db, vehicleStateFilter{}, orb.Point{20, 30.001}, 1000, 0)
+		verify.Ok(t, err)
+		outside, err := getNearbyVehicleStates(logger, db, vehicleStateFilter{}, orb.Point{20, 31}, 1000, 0)
+		verify.Ok(t, err)
+		nearest, err := getNearbyVehicleStates(logger, db, vehicleStateFilter{}, orb.Point{20, 31}, 0, 1)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(within))
+		verify.Condition(t, within[0].Distance > 100 && within[0].Distance < 120)
+		verify.Equals(t, 0, len(outside))
+		verify.Equals(t, 1, len(nearest))
+	})
+
+	t.Run("add with unknown vehicle", func(t *testing.T) {
+		// action
+		_, err := addVehicleState(logger, db, vehicleState{VehicleID: vehicleID + 1, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()})
+		// verify
+		verify.Equals(t, ErrorUnknownVehicle, err)
 	})
 
 	t.Run("delete by id", func(t *testing.T) {
@@ -80,3 +196,189 @@ func TestVehicleStateSchemaIntegration(t *testing.T) {
 		verify.Equals(t, pgx.ErrNoRows, err)
 	})
 }
+
+func TestVehicleStateFilter(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		// action
+		where, args := vehicleStateFilter{}.where()
+		// verify
+		verify.Equals(t, "", where)
+		verify.Equals(t, 0, len(args))
+	})
+
+	t.Run("vehicle and bbox crossing antimeridian", func(t *testing.T) {
+		// arrange
+		unit := vehicleStateFilter{
+			VehicleID: 7,
+			BBox:      &orb.Bound{Min: orb.Point{170, -20}, Max: orb.Point{-170, -10}},
+		}
+		// action
+		where, args := unit.where()
+		// verify
+		verify.Equals(t, "WHERE vehicle_id = $1 AND ("+
+			"position::geometry && ST_MakeEnvelope($2, $3, $4, $5, 4326) OR "+
+			"position::geometry && ST_MakeEnvelope($6, $7, $8, $9, 4326))", where)
+		verify.Equals(t, []interface{}{int64(7), 170.0, -20.0, 180.0, -10.0, -180.0, -20.0, -170.0, -10.0}, args)
+	})
+
+	t.Run("time range is passed as UTC", func(t *testing.T) {
+		// arrange
+		from := time.Date(2021, 6, 15, 11, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		to := from.Add(time.Hour)
+		// action
+		where, args := vehicleStateFilter{From: &from, To: &to}.where()
+		// verify
+		verify.Equals(t, "WHERE state_timestamp >= $1 AND state_timestamp < $2", where)
+		verify.Equals(t, []interface{}{from.UTC(), to.UTC()}, args)
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		// arrange
+		attributes := map[string]interface{}{"door": "open"}
+		// action
+		where, args := vehicleStateFilter{Attributes: attributes}.where()
+		// verify
+		verify.Equals(t, "WHERE attributes @> $1", where)
+		verify.Equals(t, []interface{}{attributes}, args)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		verify.Equals(t, "ORDER BY state_timestamp, id", vehicleStateFilter{}.orderBy())
+		verify.Equals(t, "ORDER BY state_timestamp DESC, id DESC", vehicleStateFilter{Descending: true}.orderBy())
+	})
+}
+
+func TestAddVehicleStatesSchemaIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+	verify.Ok(t, createTableVehicle(logger, db))
+	verify.Ok(t, createTableVehicleState(logger, db))
+	verify.Ok(t, createTableVehicleStateCommit(logger, db))
+	verify.Ok(t, createTableGeofence(logger, db))
+	verify.Ok(t, createTableGeofenceEvent(logger, db))
+	vehicleID, _ := addVehicle(logger, db, vehicle{Name: "truck"})
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	states := make([]vehicleState, 1000)
+	for i := range states {
+		states[i] = vehicleState{
+			VehicleID: vehicleID,
+			Position:  *geojson.NewGeometry(orb.Point{20, 30 + float64(i)*0.001}),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+
+	t.Run("add many", func(t *testing.T) {
+		// action
+		ids, err := addVehicleStates(logger, db, states)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, len(states), len(ids))
+		last, err := getVehicleState(logger, db, ids[len(ids)-1])
+		verify.Ok(t, err)
+		verify.Equals(t, start.Add(999*time.Second), last.Timestamp)
+	})
+
+	t.Run("add with unknown vehicle", func(t *testing.T) {
+		// arrange
+		unknown := states[0]
+		unknown.VehicleID = vehicleID + 1
+		// action
+		_, err := addVehicleStates(logger, db, []vehicleState{states[0], unknown})
+		// verify
+		verify.Equals(t, ErrorUnknownVehicle, err)
+	})
+}
+
+func TestUpgradeTableVehicleStateIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	db, _ := integrationtest.GetDbConnectionPool()
+	// table as created by the first version
+	_, err := db.Exec(
+		context.Background(),
+		`CREATE TABLE vehicle_state
+		(
+			id              bigserial,
+			position        GEOGRAPHY(POINT, 4326) NOT NULL,
+			state_timestamp TIMESTAMP
+		)`,
+	)
+	verify.Ok(t, err)
+	var id int64
+	err = db.QueryRow(
+		context.Background(),
+		`INSERT INTO vehicle_state (position, state_timestamp) VALUES ('POINT(20 30)', '2021-06-15 09:00:00') RETURNING id`,
+	).Scan(&id)
+	verify.Ok(t, err)
+
+	var vehicleID int64
+	t.Run("upgrade", func(t *testing.T) {
+		// action
+		verify.Ok(t, createTableVehicle(logger, db))
+		err := createTableVehicleState(logger, db)
+		// verify
+		verify.Ok(t, err)
+		state, err := getVehicleState(logger, db, id)
+		verify.Ok(t, err)
+		placeholder, err := getVehicle(logger, db, state.VehicleID)
+		verify.Ok(t, err)
+		verify.Equals(t, placeholderVehicleName, placeholder.Name)
+		verify.Equals(t, orb.Point{20, 30}, state.Position.Geometry())
+		verify.Assert(t, state.Speed == nil && state.Attributes == nil, "unexpected telemetry %v", state)
+		vehicleID = state.VehicleID
+	})
+
+	t.Run("upgrade again", func(t *testing.T) {
+		// action
+		err := createTableVehicleState(logger, db)
+		// verify
+		verify.Ok(t, err)
+		vehicles, err := getVehicles(logger, db)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(vehicles))
+	})
+
+	t.Run("telemetry of new states", func(t *testing.T) {
+		// arrange
+		speed := 12.5
+		state := vehicleState{
+			VehicleID:  vehicleID,
+			Position:   *geojson.NewGeometry(orb.Point{20, 31}),
+			Timestamp:  time.Date(2021, 6, 15, 9, 1, 0, 0, time.UTC),
+			Speed:      &speed,
+			Attributes: map[string]interface{}{"door": "closed"},
+		}
+		verify.Ok(t, createTableVehicleStateCommit(logger, db))
+		verify.Ok(t, createTableGeofence(logger, db))
+		verify.Ok(t, createTableGeofenceEvent(logger, db))
+		// action
+		newID, err := addVehicleState(logger, db, state)
+		verify.Ok(t, err)
+		result, err := getVehicleState(logger, db, newID)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, &speed, result.Speed)
+		verify.Equals(t, state.Attributes, result.Attributes)
+	})
+
+	t.Run("states of unknown vehicles are rejected", func(t *testing.T) {
+		// action
+		_, err := db.Exec(
+			context.Background(),
+			`INSERT INTO vehicle_state (vehicle_id, position) VALUES (-1, 'POINT(20 30)')`,
+		)
+		// verify
+		verify.Assert(t, err != nil, "missing foreign key")
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// streamReadTimeout is the time allowed for each read of a streamed request body.
+	streamReadTimeout = 30 * time.Second
+	// streamWriteTimeout is the time allowed for each write of a streamed response.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// connContextKey is the context key of the connection a request was received on, see withConn.
+type connContextKey struct{}
+
+// withConn stores the connection in the context of its requests, so that handlers of streamed
+// requests can replace the server wide read and write timeouts, see setReadDeadline.
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// setReadDeadline replaces the read deadline of the connection of the request, a zero deadline
+// disables it. Requests served without withConn, e.g. in tests, are not affected.
+func setReadDeadline(c *gin.Context, deadline time.Time) {
+	if conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn); ok {
+		conn.SetReadDeadline(deadline)
+	}
+}
+
+// setWriteDeadline replaces the write deadline of the connection of the request, see setReadDeadline.
+func setWriteDeadline(c *gin.Context, deadline time.Time) {
+	if conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+}
+
+// startStream lifts the server wide timeouts for a streamed response. The connection is not read
+// any further, each write has to be preceded by extendWriteDeadline.
+func startStream(c *gin.Context) {
+	setReadDeadline(c, time.Time{})
+	extendWriteDeadline(c)
+}
+
+// extendWriteDeadline allows the next write of a streamed response to take streamWriteTimeout.
+func extendWriteDeadline(c *gin.Context) {
+	setWriteDeadline(c, time.Now().Add(streamWriteTimeout))
+}
+
+// deadlineReader extends the read deadline of a streamed request body before each read,
+// so that slow clients are disconnected but long uploads are not.
+type deadlineReader struct {
+	c *gin.Context
+	r io.Reader
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	setReadDeadline(r.c, time.Now().Add(streamReadTimeout))
+	return r.r.Read(p)
+}
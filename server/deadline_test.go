@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+)
+
+// newDeadlineTestServer serves router with short server wide timeouts.
+func newDeadlineTestServer(router *gin.Engine) *httptest.Server {
+	server := httptest.NewUnstartedServer(router)
+	server.Config.ReadTimeout = 50 * time.Millisecond
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Config.ConnContext = withConn
+	server.Start()
+	return server
+}
+
+func TestDeadlines(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/upload", func(c *gin.Context) {
+		data, err := ioutil.ReadAll(deadlineReader{c: c, r: c.Request.Body})
+		if err != nil {
+			c.Status(http.StatusRequestTimeout)
+			return
+		}
+		extendWriteDeadline(c)
+		c.String(http.StatusOK, string(data))
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		startStream(c)
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(150 * time.Millisecond):
+		}
+		extendWriteDeadline(c)
+		c.String(http.StatusOK, "done")
+	})
+	server := newDeadlineTestServer(router)
+	defer server.Close()
+
+	t.Run("Slow upload is read completely", func(t *testing.T) {
+		// arrange
+		body, w := io.Pipe()
+		go func() {
+			for i := 0; i < 3; i++ {
+				time.Sleep(40 * time.Millisecond)
+				w.Write([]byte("a"))
+			}
+			w.Close()
+		}()
+		// action
+		res, err := http.Post(server.URL+"/upload", "text/plain", body)
+		verify.Ok(t, err)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, http.StatusOK, res.StatusCode)
+		verify.Equals(t, "aaa", string(data))
+	})
+
+	t.Run("Stream outlasts the server wide timeouts", func(t *testing.T) {
+		// action
+		res, err := http.Get(server.URL + "/stream")
+		verify.Ok(t, err)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, "done", string(data))
+	})
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// DistanceConfig holds the GPS jitter filter applied when summing up the distance travelled.
+type DistanceConfig struct {
+	// MinDistance in metres a vehicle has to move away from the last counted position.
+	// Smaller movements, like the scattering positions of a parked vehicle, are ignored.
+	MinDistance float64
+	// MaxAccuracy in metres. States reporting a worse accuracy are ignored, 0 accepts all states.
+	MaxAccuracy float64
+}
+
+// DefaultDistanceConfig is used unless configured otherwise, see WithDistanceConfig.
+var DefaultDistanceConfig = DistanceConfig{
+	MinDistance: 20,
+	MaxAccuracy: 0,
+}
+
+// distanceBucket is the distance travelled within the hour or day beginning at Start.
+type distanceBucket struct {
+	Start    time.Time `json:"start"`
+	Distance float64   `json:"distance"`
+	// Odometer is the difference of the odometer readings, if reported.
+	Odometer *float64 `json:"odometer,omitempty"`
+}
+
+// vehicleDistance is the distance a vehicle travelled in metres, computed from its positions.
+type vehicleDistance struct {
+	VehicleID int64            `json:"vehicleId"`
+	Distance  float64          `json:"distance"`
+	Odometer  *float64         `json:"odometer,omitempty"`
+	Buckets   []distanceBucket `json:"buckets,omitempty"`
+}
+
+// distanceCounter sums up the distance between the time ordered states of a single vehicle.
+// Each movement is counted in the bucket of the state it ends with.
+type distanceCounter struct {
+	config   DistanceConfig
+	bucketOf func(time.Time) time.Time
+
+	counted      bool
+	last         orb.Point
+	lastOdometer *float64
+	result       vehicleDistance
+}
+
+// newDistanceCounter creates a counter grouping the distance by the given bucket
+// (hour, day or empty for none), starting the buckets at midnight in loc.
+func newDistanceCounter(vehicleID int64, config DistanceConfig, bucket string, loc *time.Location) (*distanceCounter, error) {
+	counter := &distanceCounter{config: config, result: vehicleDistance{VehicleID: vehicleID}}
+	switch bucket {
+	case "":
+	case timeBucketHour:
+		counter.bucketOf = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+	case timeBucketDay:
+		counter.bucketOf = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+	default:
+		return nil, fmt.Errorf("bucket must be hour or day")
+	}
+	return counter, nil
+}
+
+// add counts the movement to the given state.
+func (counter *distanceCounter) add(state vehicleState) {
+	if counter.config.MaxAccuracy > 0 && state.Accuracy != nil && *state.Accuracy > counter.config.MaxAccuracy {
+		return
+	}
+	point, ok := state.Position.Geometry().(orb.Point)
+	if !ok {
+		return
+	}
+
+	var bucket *distanceBucket
+	if counter.bucketOf != nil {
+		start := counter.bucketOf(state.Timestamp)
+		n := len(counter.result.Buckets)
+		if n == 0 || !counter.result.Buckets[n-1].Start.Equal(start) {
+			counter.result.Buckets = append(counter.result.Buckets, distanceBucket{Start: start})
+			n++
+		}
+		bucket = &counter.result.Buckets[n-1]
+	}
+
+	if state.Odometer != nil {
+		// a decreasing reading means a reset or replaced odometer, which is counted from its new reading on
+		if counter.lastOdometer != nil && *state.Odometer >= *counter.lastOdometer {
+			delta := *state.Odometer - *counter.lastOdometer
+			counter.result.Odometer = addOptional(counter.result.Odometer, delta)
+			if bucket != nil {
+				bucket.Odometer = addOptional(bucket.Odometer, delta)
+			}
+		}
+		counter.lastOdometer = state.Odometer
+	}
+
+	if !counter.counted {
+		counter.counted, counter.last = true, point
+		return
+	}
+	distance := geo.DistanceHaversine(counter.last, point)
+	if distance < counter.config.MinDistance {
+		return
+	}
+	counter.last = point
+	counter.result.Distance += distance
+	if bucket != nil {
+		bucket.Distance += distance
+	}
+}
+
+func addOptional(sum *float64, value float64) *float64 {
+	if sum != nil {
+		value += *sum
+	}
+	return &value
+}
+
+// distanceCounters counts the distance of many vehicles, see distanceCounter.
+type distanceCounters struct {
+	config   DistanceConfig
+	bucket   string
+	location *time.Location
+	counters map[int64]*distanceCounter
+}
+
+func newDistanceCounters(config DistanceConfig, bucket string, loc *time.Location) (*distanceCounters, error) {
+	// validate bucket once
+	if _, err := newDistanceCounter(0, config, bucket, loc); err != nil {
+		return nil, err
+	}
+	return &distanceCounters{config: config, bucket: bucket, location: loc, counters: map[int64]*distanceCounter{}}, nil
+}
+
+// add counts the movement of the vehicle to the given state.
+func (counters *distanceCounters) add(state vehicleState) error {
+	counter, ok := counters.counters[state.VehicleID]
+	if !ok {
+		var err error
+		counter, err = newDistanceCounter(state.VehicleID, counters.config, counters.bucket, counters.location)
+		if err != nil {
+			return err
+		}
+		counters.counters[state.VehicleID] = counter
+	}
+	counter.add(state)
+	return nil
+}
+
+// results returns the distances of all vehicles, ordered by vehicle id.
+func (counters *distanceCounters) results() []vehicleDistance {
+	results := make([]vehicleDistance, 0, len(counters.counters))
+	for _, counter := range counters.counters {
+		results = append(results, counter.result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].VehicleID < results[j].VehicleID })
+	return results
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestDistanceCounter(t *testing.T) {
+	start := time.Date(2021, 6, 15, 21, 0, 0, 0, time.UTC)
+	state := func(minutes int, lat float64) vehicleState {
+		return vehicleState{
+			VehicleID: 1,
+			Position:  *geojson.NewGeometry(orb.Point{13.0, lat}),
+			Timestamp: start.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+
+	t.Run("jitter is ignored", func(t *testing.T) {
+		// arrange
+		unit, _ := newDistanceCounter(1, DistanceConfig{MinDistance: 20}, "", time.UTC)
+		// action
+		unit.add(state(0, 52.0))
+		unit.add(state(1, 52.0001)) // about 11 m
+		unit.add(state(2, 52.0))
+		unit.add(state(3, 52.0001))
+		unit.add(state(4, 52.01)) // about 1.1 km
+		// verify
+		verify.Assert(t, unit.result.Distance > 1100 && unit.result.Distance < 1115, "unexpected distance %v", unit.result.Distance)
+		verify.Equals(t, 0, len(unit.result.Buckets))
+	})
+
+	t.Run("slow movement is counted", func(t *testing.T) {
+		// arrange
+		unit, _ := newDistanceCounter(1, DistanceConfig{MinDistance: 20}, "", time.UTC)
+		// action
+		for i := 0; i <= 10; i++ {
+			unit.add(state(i, 52.0+float64(i)*0.0001))
+		}
+		// verify
+		verify.Assert(t, unit.result.Distance > 100 && unit.result.Distance < 112, "unexpected distance %v", unit.result.Distance)
+	})
+
+	t.Run("inaccurate states are ignored", func(t *testing.T) {
+		// arrange
+		unit, _ := newDistanceCounter(1, DistanceConfig{MaxAccuracy: 50}, "", time.UTC)
+		accuracy := 500.0
+		inaccurate := state(1, 52.01)
+		inaccurate.Accuracy = &accuracy
+		// action
+		unit.add(state(0, 52.0))
+		unit.add(inaccurate)
+		unit.add(state(2, 52.0))
+		// verify
+		verify.Equals(t, 0.0, unit.result.Distance)
+	})
+
+	t.Run("buckets by day in time zone", func(t *testing.T) {
+		// arrange
+		berlin, _ := time.LoadLocation("Europe/Berlin")
+		unit, _ := newDistanceCounter(1, DistanceConfig{}, timeBucketDay, berlin)
+		// action
+		unit.add(state(0, 52.0))
+		unit.add(state(30, 52.01))
+		unit.add(state(90, 52.02)) // 22:30 UTC is the next day in Berlin
+		unit.add(state(120, 52.03))
+		// verify
+		verify.Equals(t, 2, len(unit.result.Buckets))
+		verify.Equals(t, time.Date(2021, 6, 16, 0, 0, 0, 0, berlin), unit.result.Buckets[1].Start)
+		verify.Assert(t, unit.result.Buckets[0].Distance > 1100 && unit.result.Buckets[0].Distance < 1115, "unexpected distance %v", unit.result.Buckets[0].Distance)
+		verify.Equals(t, unit.result.Distance, unit.result.Buckets[0].Distance+unit.result.Buckets[1].Distance)
+	})
+
+	t.Run("odometer", func(t *testing.T) {
+		// arrange
+		unit, _ := newDistanceCounter(1, DistanceConfig{}, timeBucketHour, time.UTC)
+		withOdometer := func(minutes int, odometer float64) vehicleState {
+			s := state(minutes, 52.0)
+			s.Odometer = &odometer
+			return s
+		}
+		// action
+		unit.add(withOdometer(0, 1000))
+		unit.add(withOdometer(30, 1500))
+		unit.add(state(45, 52.0))
+		unit.add(withOdometer(70, 2500))
+		// verify
+		verify.Equals(t, 1500.0, *unit.result.Odometer)
+		verify.Equals(t, 2, len(unit.result.Buckets))
+		verify.Equals(t, 500.0, *unit.result.Buckets[0].Odometer)
+		verify.Equals(t, 1000.0, *unit.result.Buckets[1].Odometer)
+	})
+
+	t.Run("odometer reset", func(t *testing.T) {
+		// arrange
+		unit, _ := newDistanceCounter(1, DistanceConfig{}, "", time.UTC)
+		withOdometer := func(minutes int, odometer float64) vehicleState {
+			s := state(minutes, 52.0)
+			s.Odometer = &odometer
+			return s
+		}
+		// action
+		unit.add(withOdometer(0, 1000))
+		unit.add(withOdometer(30, 1500))
+		unit.add(withOdometer(40, 0))
+		unit.add(withOdometer(50, 200))
+		// verify
+		verify.Equals(t, 700.0, *unit.result.Odometer)
+	})
+
+	t.Run("unknown bucket", func(t *testing.T) {
+		// action
+		_, err := newDistanceCounter(1, DistanceConfig{}, "week", time.UTC)
+		// verify
+		verify.Assert(t, err != nil, "expected error")
+	})
+}
+
+func TestDistanceCounters(t *testing.T) {
+	// arrange
+	unit, _ := newDistanceCounters(DistanceConfig{}, "", time.UTC)
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	// action
+	for _, s := range []struct {
+		vehicleID int64
+		lat       float64
+	}{{2, 52.0}, {1, 52.0}, {2, 52.01}, {1, 52.0}} {
+		err := unit.add(vehicleState{VehicleID: s.vehicleID, Position: *geojson.NewGeometry(orb.Point{13.0, s.lat}), Timestamp: start})
+		verify.Ok(t, err)
+	}
+	result := unit.results()
+	// verify
+	verify.Equals(t, 2, len(result))
+	verify.Equals(t, int64(1), result[0].VehicleID)
+	verify.Equals(t, 0.0, result[0].Distance)
+	verify.Equals(t, int64(2), result[1].VehicleID)
+	verify.Condition(t, result[1].Distance > 1100)
+}
+
+func TestGetDistancesInvalidQuery(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	for _, query := range []string{"bucket=week", "tz=Mars/Olympus", "minDistance=-1", "from=yesterday", "", "from=2021-06-01T00:00:00Z", "from=2021-06-01T00:00:00Z&to=2021-08-01T00:00:00Z"} {
+		t.Run(query, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/vehicles/distance?"+query, nil)
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusBadRequest, res.Code)
+		})
+	}
+}
@@ -1,5 +1,18 @@
 package server
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
 
 var ErrorNotFound = errors.New("no rows in result set")
+
+// ErrorUnknownVehicle is returned if a vehicle state references a vehicle that does not exist.
+var ErrorUnknownVehicle = errors.New("referenced vehicle does not exist")
+
+// isForeignKeyViolation reports whether err was caused by a violated foreign key constraint.
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+)
+
+const mimeCSV = "text/csv"
+
+// csvOptions control how CSV exports are written.
+type csvOptions struct {
+	Delimiter rune
+	Location  *time.Location
+}
+
+// csvOptionsFromQuery reads the optional query parameters delimiter (a single character
+// or "tab", default ",") and tz (IANA time zone used for timestamps, default UTC).
+func csvOptionsFromQuery(c *gin.Context) (csvOptions, error) {
+	opts := csvOptions{Delimiter: ',', Location: time.UTC}
+	if delimiter := c.Query("delimiter"); delimiter == "tab" {
+		opts.Delimiter = '\t'
+	} else if delimiter != "" {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return opts, errors.New("delimiter must be a single character other than quote or newline")
+		}
+		opts.Delimiter = r
+	}
+	if tz := c.Query("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return opts, err
+		}
+		opts.Location = location
+	}
+	return opts, nil
+}
+
+var vehicleStateCSVHeader = []string{
+	"id", "vehicleId", "lon", "lat", "timestamp",
+	"speed", "heading", "altitude", "accuracy", "odometer", "ignition", "attributes",
+}
+
+// vehicleStateCSVRecord converts a vehicle state into a row matching vehicleStateCSVHeader.
+// Missing telemetry is left empty, attributes are written as JSON object.
+func vehicleStateCSVRecord(state vehicleState, location *time.Location) ([]string, error) {
+	var lon, lat string
+	if p, ok := state.Position.Geometry().(orb.Point); ok {
+		lon = formatCSVFloat(p.Lon())
+		lat = formatCSVFloat(p.Lat())
+	}
+	var ignition, attributes string
+	if state.Ignition != nil {
+		ignition = strconv.FormatBool(*state.Ignition)
+	}
+	if len(state.Attributes) > 0 {
+		data, err := json.Marshal(state.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		attributes = string(data)
+	}
+	return []string{
+		strconv.FormatInt(state.ID, 10),
+		strconv.FormatInt(state.VehicleID, 10),
+		lon,
+		lat,
+		state.Timestamp.In(location).Format(time.RFC3339),
+		formatCSVOptionalFloat(state.Speed),
+		formatCSVOptionalFloat(state.Heading),
+		formatCSVOptionalFloat(state.Altitude),
+		formatCSVOptionalFloat(state.Accuracy),
+		formatCSVOptionalFloat(state.Odometer),
+		ignition,
+		attributes,
+	}, nil
+}
+
+var userCSVHeader = []string{"id", "name"}
+
+// userCSVRecord converts a user into a row matching userCSVHeader.
+func userCSVRecord(id int64, user user) []string {
+	return []string{strconv.FormatInt(id, 10), user.Name}
+}
+
+func formatCSVFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatCSVOptionalFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return formatCSVFloat(*v)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestCSVOptionsFromQuery(t *testing.T) {
+	var tests = []struct {
+		query     string
+		delimiter rune
+		location  string
+		valid     bool
+	}{
+		{"", ',', "UTC", true},
+		{"?delimiter=%3B&tz=Europe/Berlin", ';', "Europe/Berlin", true},
+		{"?delimiter=tab", '\t', "UTC", true},
+		{"?delimiter=%C2%A7", '§', "UTC", true},
+		{"?delimiter=ab", 0, "", false},
+		{"?delimiter=%22", 0, "", false},
+		{"?tz=Mars/Olympus", 0, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			// arrange
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/"+test.query, nil)
+			// action
+			result, err := csvOptionsFromQuery(c)
+			// verify
+			if !test.valid {
+				verify.Assert(t, err != nil, "expected error")
+				return
+			}
+			verify.Ok(t, err)
+			verify.Equals(t, test.delimiter, result.Delimiter)
+			verify.Equals(t, test.location, result.Location.String())
+		})
+	}
+}
+
+func TestVehicleStateCSVRecord(t *testing.T) {
+	t.Run("position only", func(t *testing.T) {
+		// arrange
+		state := vehicleState{ID: 1, VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20.5, 30}), Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)}
+		// action
+		result, err := vehicleStateCSVRecord(state, time.UTC)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, []string{"1", "7", "20.5", "30", "2021-06-15T09:00:00Z", "", "", "", "", "", "", ""}, result)
+		verify.Equals(t, len(vehicleStateCSVHeader), len(result))
+	})
+
+	t.Run("telemetry in time zone", func(t *testing.T) {
+		// arrange
+		speed, ignition := 12.5, false
+		state := vehicleState{
+			ID:         1,
+			VehicleID:  7,
+			Position:   *geojson.NewGeometry(orb.Point{20, 30}),
+			Timestamp:  time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC),
+			Speed:      &speed,
+			Ignition:   &ignition,
+			Attributes: map[string]interface{}{"door": "open"},
+		}
+		location := time.FixedZone("CEST", 2*60*60)
+		// action
+		result, err := vehicleStateCSVRecord(state, location)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, []string{"1", "7", "20", "30", "2021-06-15T11:00:00+02:00", "12.5", "", "", "", "", "false", `{"door":"open"}`}, result)
+	})
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// vehicleStateFromFeature converts a GeoJSON Point feature into a vehicle state.
+// All fields but the position are read from the feature properties.
+func vehicleStateFromFeature(feature *geojson.Feature) (vehicleState, error) {
+	var state vehicleState
+	properties, err := json.Marshal(feature.Properties)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(properties, &state)
+	if err != nil {
+		return state, err
+	}
+	state.ID = 0
+	state.Position = geojson.Geometry{}
+	if feature.Geometry != nil {
+		state.Position = *geojson.NewGeometry(feature.Geometry)
+	}
+	return state, nil
+}
+
+// newVehicleStateFeature converts a vehicle state into a GeoJSON Point feature,
+// the reverse of vehicleStateFromFeature. The state id becomes the feature id.
+func newVehicleStateFeature(state vehicleState) (*geojson.Feature, error) {
+	feature := geojson.NewFeature(state.Position.Geometry())
+	feature.ID = state.ID
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &feature.Properties)
+	if err != nil {
+		return nil, err
+	}
+	delete(feature.Properties, "id")
+	delete(feature.Properties, "position")
+	return feature, nil
+}
+
+// newVehicleStateFeatureCollection converts vehicle states into a GeoJSON feature collection
+// with the bounding box of all positions. Empty collections have no bounding box.
+func newVehicleStateFeatureCollection(states []vehicleState) (*geojson.FeatureCollection, error) {
+	collection := geojson.NewFeatureCollection()
+	var bound orb.Bound
+	for i, state := range states {
+		feature, err := newVehicleStateFeature(state)
+		if err != nil {
+			return nil, err
+		}
+		if feature.Geometry != nil {
+			if i == 0 {
+				bound = feature.Geometry.Bound()
+			} else {
+				bound = bound.Union(feature.Geometry.Bound())
+			}
+		}
+		collection.Append(feature)
+	}
+	if len(states) > 0 {
+		collection.BBox = geojson.NewBBox(bound)
+	}
+	return collection, nil
+}
+
+// newGeofenceFeature converts a geofence into a GeoJSON feature with its area as geometry.
+// The geofence id becomes the feature id, name and category are kept as properties.
+func newGeofenceFeature(fence geofence) *geojson.Feature {
+	feature := geojson.NewFeature(fence.Area.Geometry())
+	feature.ID = fence.ID
+	feature.Properties["name"] = fence.Name
+	feature.Properties["category"] = fence.Category
+	return feature
+}
+
+// newGeofenceFeatureCollection converts geofences into a GeoJSON feature collection.
+func newGeofenceFeatureCollection(fences []geofence) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+	for _, fence := range fences {
+		collection.Append(newGeofenceFeature(fence))
+	}
+	return collection
+}
+
+// newGridFeatureCollection converts grid cells of the given size in web mercator metres
+// into a GeoJSON feature collection of polygons. Each feature carries the count of states,
+// the number of distinct vehicles and the start of its time bucket, if any.
+func newGridFeatureCollection(cells []gridCell, cellSize float64) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+	for _, cell := range cells {
+		min := mercatorToLonLat(orb.Point{float64(cell.X) * cellSize, float64(cell.Y) * cellSize})
+		max := mercatorToLonLat(orb.Point{float64(cell.X+1) * cellSize, float64(cell.Y+1) * cellSize})
+		feature := geojson.NewFeature(orb.Bound{Min: min, Max: max}.ToPolygon())
+		feature.Properties["count"] = cell.Count
+		feature.Properties["vehicles"] = cell.Vehicles
+		if cell.Start != nil {
+			feature.Properties["start"] = *cell.Start
+		}
+		collection.Append(feature)
+	}
+	return collection
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestVehicleStateFromFeature(t *testing.T) {
+	// arrange
+	feature := geojson.NewFeature(orb.Point{20, 30})
+	feature.Properties["vehicleId"] = 1
+	feature.Properties["timestamp"] = "2021-06-15T09:00:00Z"
+	feature.Properties["speed"] = 12.5
+	feature.Properties["attributes"] = map[string]interface{}{"door": "open"}
+	// action
+	result, err := vehicleStateFromFeature(feature)
+	// verify
+	verify.Ok(t, err)
+	verify.Equals(t, int64(1), result.VehicleID)
+	verify.Equals(t, orb.Point{20, 30}, result.Position.Geometry())
+	verify.Equals(t, time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC), result.Timestamp)
+	verify.Equals(t, 12.5, *result.Speed)
+	verify.Equals(t, map[string]interface{}{"door": "open"}, result.Attributes)
+}
+
+func TestNewVehicleStateFeatureCollection(t *testing.T) {
+	t.Run("states", func(t *testing.T) {
+		// arrange
+		speed := 12.5
+		states := []vehicleState{
+			{ID: 1, VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC), Speed: &speed},
+			{ID: 2, VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{21, 29}), Timestamp: time.Date(2021, 6, 15, 9, 1, 0, 0, time.UTC)},
+		}
+		// action
+		result, err := newVehicleStateFeatureCollection(states)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result.Features))
+		verify.Equals(t, int64(1), result.Features[0].ID)
+		verify.Equals(t, orb.Point{20, 30}, result.Features[0].Geometry)
+		verify.Equals(t, 7.0, result.Features[0].Properties["vehicleId"])
+		verify.Equals(t, "2021-06-15T09:00:00Z", result.Features[0].Properties["timestamp"])
+		verify.Equals(t, 12.5, result.Features[0].Properties["speed"])
+		_, hasPosition := result.Features[0].Properties["position"]
+		verify.Assert(t, !hasPosition, "position is duplicated in properties")
+		verify.Equals(t, orb.Bound{Min: orb.Point{20, 29}, Max: orb.Point{21, 30}}, result.BBox.Bound())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		// action
+		result, err := newVehicleStateFeatureCollection(nil)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 0, len(result.Features))
+		verify.Assert(t, result.BBox == nil, "bbox is set")
+	})
+}
+
+func TestNewGeofenceFeatureCollection(t *testing.T) {
+	// arrange
+	area := orb.Polygon{{{19, 29}, {21, 29}, {21, 31}, {19, 31}, {19, 29}}}
+	fences := []geofence{{ID: 3, Name: "depot", Category: "yard", Area: *geojson.NewGeometry(area)}}
+	// action
+	result := newGeofenceFeatureCollection(fences)
+	// verify
+	verify.Equals(t, 1, len(result.Features))
+	verify.Equals(t, int64(3), result.Features[0].ID)
+	verify.Equals(t, area, result.Features[0].Geometry)
+	verify.Equals(t, "depot", result.Features[0].Properties["name"])
+	verify.Equals(t, "yard", result.Features[0].Properties["category"])
+}
+
+func TestNewGridFeatureCollection(t *testing.T) {
+	// arrange
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	cells := []gridCell{
+		{X: 0, Y: 0, Count: 3, Vehicles: 1},
+		{X: -1, Y: 2, Start: &start, Count: 1, Vehicles: 1},
+	}
+	// action
+	result := newGridFeatureCollection(cells, 1000)
+	// verify
+	verify.Equals(t, 2, len(result.Features))
+	bound := result.Features[0].Geometry.Bound()
+	verify.Equals(t, orb.Point{0, 0}, bound.Min)
+	verify.Condition(t, math.Abs(bound.Max.Lon()-0.008983) < 0.000001)
+	verify.Condition(t, math.Abs(bound.Max.Lat()-0.008983) < 0.000001)
+	verify.Equals(t, int64(3), result.Features[0].Properties["count"])
+	_, bucketed := result.Features[0].Properties["start"]
+	verify.Assert(t, !bucketed, "unexpected start")
+	verify.Equals(t, start, result.Features[1].Properties["start"])
+	verify.Condition(t, result.Features[1].Geometry.Bound().Max.Lon() == 0)
+}
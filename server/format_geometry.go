@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// Encodings of geometries in JSON responses, selected by the query parameter geometryFormat.
+const (
+	geometryFormatGeoJSON = "geojson"
+	geometryFormatWKT     = "wkt"
+	geometryFormatWKBHex  = "wkb-hex"
+)
+
+// geometryFormatFromQuery reads the optional query parameter geometryFormat, default is geojson.
+func geometryFormatFromQuery(c *gin.Context) (string, error) {
+	switch format := c.DefaultQuery("geometryFormat", geometryFormatGeoJSON); format {
+	case geometryFormatGeoJSON, geometryFormatWKT, geometryFormatWKBHex:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported geometryFormat %q", format)
+	}
+}
+
+// encodeGeometry returns g as GeoJSON geometry, WKT string or hex encoded WKB string.
+// A nil geometry is returned as nil.
+func encodeGeometry(g orb.Geometry, format string) (interface{}, error) {
+	if g == nil {
+		return nil, nil
+	}
+	switch format {
+	case geometryFormatWKT:
+		return wkt.MarshalString(g), nil
+	case geometryFormatWKBHex:
+		data, err := wkb.Marshal(g)
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(data), nil
+	default:
+		return geojson.NewGeometry(g), nil
+	}
+}
+
+// decodeGeometry reads a geometry given either as GeoJSON object or as string holding WKT
+// or hex encoded WKB. Missing and null geometries result in an empty geometry.
+func decodeGeometry(data json.RawMessage) (geojson.Geometry, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return geojson.Geometry{}, nil
+	}
+	if data[0] != '"' {
+		var g geojson.Geometry
+		err := json.Unmarshal(data, &g)
+		return g, err
+	}
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return geojson.Geometry{}, err
+	}
+	var g orb.Geometry
+	if wkbData, hexErr := hex.DecodeString(s); hexErr == nil && len(wkbData) > 0 {
+		g, err = wkb.Unmarshal(wkbData)
+	} else {
+		g, err = parseWKT(s)
+	}
+	if err != nil {
+		return geojson.Geometry{}, err
+	}
+	return *geojson.NewGeometry(g), nil
+}
+
+// vehicleStateOutput is a vehicle state with its position encoded as requested by geometryFormat.
+type vehicleStateOutput struct {
+	vehicleState
+	Position interface{} `json:"position"`
+}
+
+type nearbyVehicleStateOutput struct {
+	nearbyVehicleState
+	Position interface{} `json:"position"`
+}
+
+type latestVehicleStateOutput struct {
+	latestVehicleState
+	Position interface{} `json:"position"`
+}
+
+// geofenceOutput is a geofence with its area encoded as requested by geometryFormat.
+type geofenceOutput struct {
+	geofence
+	Area interface{} `json:"area"`
+}
+
+// encodeVehicleState returns state unchanged for geojson, otherwise with its position in the requested format.
+func encodeVehicleState(state vehicleState, format string) (interface{}, error) {
+	if format == geometryFormatGeoJSON {
+		return state, nil
+	}
+	position, err := encodeGeometry(state.Position.Geometry(), format)
+	return vehicleStateOutput{vehicleState: state, Position: position}, err
+}
+
+// encodeVehicleStates is like encodeVehicleState for a list of states.
+func encodeVehicleStates(states []vehicleState, format string) (interface{}, error) {
+	if format == geometryFormatGeoJSON {
+		return states, nil
+	}
+	out := make([]vehicleStateOutput, len(states))
+	for i, state := range states {
+		position, err := encodeGeometry(state.Position.Geometry(), format)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = vehicleStateOutput{vehicleState: state, Position: position}
+	}
+	return out, nil
+}
+
+// encodeNearbyVehicleStates is like encodeVehicleStates for states with distance.
+func encodeNearbyVehicleStates(states []nearbyVehicleState, format string) (interface{}, error) {
+	if format == geometryFormatGeoJSON {
+		return states, nil
+	}
+	out := make([]nearbyVehicleStateOutput, len(states))
+	for i, state := range states {
+		position, err := encodeGeometry(state.Position.Geometry(), format)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = nearbyVehicleStateOutput{nearbyVehicleState: state, Position: position}
+	}
+	return out, nil
+}
+
+// encodeLatestVehicleStates is like encodeVehicleStates for latest states.
+func encodeLatestVehicleStates(states []latestVehicleState, format string) (interface{}, error) {
+	if format == geometryFormatGeoJSON {
+		return states, nil
+	}
+	out := make([]latestVehicleStateOutput, len(states))
+	for i, state := range states {
+		position, err := encodeGeometry(state.Position.Geometry(), format)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = latestVehicleStateOutput{latestVehicleState: state, Position: position}
+	}
+	return out, nil
+}
+
+// encodeGeofence returns fence unchanged for geojson, otherwise with its area in the requested format.
+func encodeGeofence(fence geofence, format string) (interface{}, error) {
+	if format == geometryFormatGeoJSON {
+		return fence, nil
+	}
+	area, err := encodeGeometry(fence.Area.Geometry(), format)
+	return geofenceOutput{geofence: fence, Area: area}, err
+}
+
+// encodeGeofences is like encodeGeofence for a list of geofences.
+func encodeGeofences(fences []geofence, format string) (interface{}, error) {
+	if format == geometryFormatGeoJSON {
+		return fences, nil
+	}
+	out := make([]geofenceOutput, len(fences))
+	for i, fence := range fences {
+		area, err := encodeGeometry(fence.Area.Geometry(), format)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = geofenceOutput{geofence: fence, Area: area}
+	}
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGeometryFormatFromQuery(t *testing.T) {
+	for query, expected := range map[string]string{"": "geojson", "?geometryFormat=wkt": "wkt", "?geometryFormat=wkb-hex": "wkb-hex"} {
+		t.Run(query, func(t *testing.T) {
+			// arrange
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/"+query, nil)
+			// action
+			result, err := geometryFormatFromQuery(c)
+			// verify
+			verify.Ok(t, err)
+			verify.Equals(t, expected, result)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		// arrange
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?geometryFormat=gml", nil)
+		// action
+		_, err := geometryFormatFromQuery(c)
+		// verify
+		verify.Assert(t, err != nil, "expected error")
+	})
+}
+
+func TestEncodeGeometry(t *testing.T) {
+	var tests = []struct {
+		format   string
+		expected string
+	}{
+		{geometryFormatGeoJSON, `{"type":"Point","coordinates":[20,30]}`},
+		{geometryFormatWKT, `"POINT(20 30)"`},
+		{geometryFormatWKBHex, `"010100000000000000000034400000000000003e40"`},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			// action
+			result, err := encodeGeometry(orb.Point{20, 30}, test.format)
+			// verify
+			verify.Ok(t, err)
+			data, err := json.Marshal(result)
+			verify.Ok(t, err)
+			verify.Equals(t, test.expected, string(data))
+			decoded, err := decodeGeometry(data)
+			verify.Ok(t, err)
+			verify.Equals(t, orb.Point{20, 30}, decoded.Geometry())
+		})
+	}
+}
+
+func TestDecodeGeometry(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		for _, data := range []string{"", "null"} {
+			// action
+			result, err := decodeGeometry(json.RawMessage(data))
+			// verify
+			verify.Ok(t, err)
+			verify.Equals(t, geojson.Geometry{}, result)
+		}
+	})
+
+	for _, data := range []string{`""`, `"POINT(20)"`, `"0101"`, `42`} {
+		t.Run("invalid "+data, func(t *testing.T) {
+			// action
+			_, err := decodeGeometry(json.RawMessage(data))
+			// verify
+			verify.Assert(t, err != nil, "expected error")
+		})
+	}
+}
+
+func TestEncodeVehicleStates(t *testing.T) {
+	// arrange
+	states := []nearbyVehicleState{{vehicleState: vehicleState{ID: 1, VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20, 30})}, Distance: 12}}
+	// action
+	result, err := encodeNearbyVehicleStates(states, geometryFormatWKT)
+	// verify
+	verify.Ok(t, err)
+	data, err := json.Marshal(result)
+	verify.Ok(t, err)
+	verify.Equals(t, `[{"id":1,"vehicleId":7,"timestamp":"0001-01-01T00:00:00Z","distance":12,"position":"POINT(20 30)"}]`, string(data))
+}
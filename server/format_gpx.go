@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const mimeGPX = "application/gpx+xml"
+
+const gpxNamespace = "http://www.topografix.com/GPX/1/1"
+
+// gpx is a GPX 1.1 document, restricted to tracks.
+type gpx struct {
+	XMLName xml.Name   `xml:"gpx"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty"`
+	Version string     `xml:"version,attr"`
+	Creator string     `xml:"creator,attr"`
+	Tracks  []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64    `xml:"lat,attr"`
+	Lon  float64    `xml:"lon,attr"`
+	Ele  *float64   `xml:"ele,omitempty"`
+	Time *time.Time `xml:"time,omitempty"`
+}
+
+// newGPX converts the states of a vehicle into a GPX document with a single track segment.
+func newGPX(vehicle vehicle, states []vehicleState) gpx {
+	segment := gpxSegment{Points: make([]gpxPoint, 0, len(states))}
+	for _, state := range states {
+		p, ok := state.Position.Geometry().(orb.Point)
+		if !ok {
+			continue
+		}
+		timestamp := state.Timestamp.UTC()
+		segment.Points = append(segment.Points, gpxPoint{Lat: p.Lat(), Lon: p.Lon(), Ele: state.Altitude, Time: &timestamp})
+	}
+	return gpx{
+		Xmlns:   gpxNamespace,
+		Version: "1.1",
+		Creator: "go-webserver",
+		Tracks:  []gpxTrack{{Name: vehicle.Name, Segments: []gpxSegment{segment}}},
+	}
+}
+
+// writeGPX writes doc as indented XML, including the XML declaration.
+func writeGPX(w io.Writer, doc gpx) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// decodeGPXTrack reads the track points of all tracks and segments in a GPX document
+// as states of the given vehicle. The items are numbered in document order, points
+// without time are rejected. Documents with more than maxBatchSize points are an error.
+func decodeGPXTrack(r io.Reader, vehicleID int64) ([]batchItem, error) {
+	var doc gpx
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	var items []batchItem
+	for _, track := range doc.Tracks {
+		for _, segment := range track.Segments {
+			for _, point := range segment.Points {
+				if len(items) == maxBatchSize {
+					return nil, fmt.Errorf("track must not contain more than %d points", maxBatchSize)
+				}
+				state := vehicleState{
+					VehicleID: vehicleID,
+					Position:  *geojson.NewGeometry(orb.Point{point.Lon, point.Lat}),
+					Altitude:  point.Ele,
+				}
+				var err error
+				if point.Time == nil {
+					err = errors.New("track point has no time")
+				} else {
+					state.Timestamp = *point.Time
+				}
+				items = append(items, newBatchItem(len(items), state, err))
+			}
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestNewGPX(t *testing.T) {
+	// arrange
+	altitude := 120.5
+	states := []vehicleState{
+		{VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC), Altitude: &altitude},
+		{VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20, 31}), Timestamp: time.Date(2021, 6, 15, 9, 1, 0, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	// action
+	err := writeGPX(&buf, newGPX(vehicle{ID: 7, Name: "truck"}, states))
+	// verify
+	verify.Ok(t, err)
+	result := buf.String()
+	verify.Assert(t, strings.HasPrefix(result, `<?xml version="1.0" encoding="UTF-8"?>`), "xml header missing: %s", result)
+	verify.Assert(t, strings.Contains(result, `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1"`), "gpx element missing: %s", result)
+	verify.Assert(t, strings.Contains(result, `<name>truck</name>`), "name missing: %s", result)
+	verify.Assert(t, strings.Contains(result, `<trkpt lat="30" lon="20">`), "point missing: %s", result)
+	verify.Assert(t, strings.Contains(result, `<ele>120.5</ele>`), "elevation missing: %s", result)
+	verify.Assert(t, strings.Contains(result, `<time>2021-06-15T09:01:00Z</time>`), "time missing: %s", result)
+}
+
+func TestDecodeGPXTrack(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		// arrange
+		altitude := 120.5
+		states := []vehicleState{
+			{VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC), Altitude: &altitude},
+		}
+		var buf bytes.Buffer
+		verify.Ok(t, writeGPX(&buf, newGPX(vehicle{Name: "truck"}, states)))
+		// action
+		result, err := decodeGPXTrack(&buf, 8)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result))
+		verify.Ok(t, result[0].err)
+		verify.Equals(t, int64(8), result[0].state.VehicleID)
+		verify.Equals(t, orb.Point{20, 30}, result[0].state.Position.Geometry())
+		verify.Equals(t, states[0].Timestamp, result[0].state.Timestamp)
+		verify.Equals(t, altitude, *result[0].state.Altitude)
+	})
+
+	t.Run("several segments", func(t *testing.T) {
+		// arrange
+		testdata := `<?xml version="1.0"?>
+			<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+				<trk>
+					<trkseg>
+						<trkpt lat="30" lon="20"><time>2021-06-15T11:00:00+02:00</time></trkpt>
+						<trkpt lat="30.1" lon="20"></trkpt>
+					</trkseg>
+					<trkseg>
+						<trkpt lat="95" lon="20"><time>2021-06-15T09:02:00Z</time></trkpt>
+					</trkseg>
+				</trk>
+			</gpx>`
+		// action
+		result, err := decodeGPXTrack(strings.NewReader(testdata), 7)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 3, len(result))
+		verify.Ok(t, result[0].err)
+		verify.Assert(t, result[0].state.Timestamp.Equal(time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)), "unexpected time %v", result[0].state.Timestamp)
+		verify.Assert(t, result[1].err != nil, "expected error for point without time")
+		verify.Assert(t, result[2].err != nil, "expected error for point out of range")
+		verify.Equals(t, 2, result[2].index)
+	})
+
+	t.Run("too many points", func(t *testing.T) {
+		// arrange
+		testdata := `<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1"><trk><trkseg>` +
+			strings.Repeat(`<trkpt lat="30" lon="20"></trkpt>`, maxBatchSize+1) +
+			`</trkseg></trk></gpx>`
+		// action
+		_, err := decodeGPXTrack(strings.NewReader(testdata), 7)
+		// verify
+		verify.Assert(t, err != nil, "expected error")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		// action
+		_, err := decodeGPXTrack(strings.NewReader("<gpx"), 7)
+		// verify
+		verify.Assert(t, err != nil, "expected error")
+	})
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const mimeKML = "application/vnd.google-earth.kml+xml"
+
+const (
+	kmlNamespace   = "http://www.opengis.net/kml/2.2"
+	kmlGxNamespace = "http://www.google.com/kml/ext/2.2"
+	kmlStyleID     = "default"
+)
+
+// kml is a KML 2.2 document with a single shared style. encoding/xml cannot write
+// namespace prefixes, so the gx prefix is declared on the root and used literally.
+type kml struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	XmlnsGx  string      `xml:"xmlns:gx,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name,omitempty"`
+	Style      kmlStyleXML    `xml:"Style"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlStyleXML struct {
+	ID        string `xml:"id,attr"`
+	LineColor string `xml:"LineStyle>color"`
+	LineWidth string `xml:"LineStyle>width"`
+	PolyColor string `xml:"PolyStyle>color"`
+}
+
+type kmlPlacemark struct {
+	Name          string            `xml:"name,omitempty"`
+	StyleURL      string            `xml:"styleUrl"`
+	ExtendedData  []kmlData         `xml:"ExtendedData>Data,omitempty"`
+	Point         *kmlPoint         `xml:"Point,omitempty"`
+	LineString    *kmlLineString    `xml:"LineString,omitempty"`
+	Polygon       *kmlPolygon       `xml:"Polygon,omitempty"`
+	MultiGeometry *kmlMultiGeometry `xml:"MultiGeometry,omitempty"`
+	Track         *kmlTrack         `xml:"gx:Track,omitempty"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlLineString struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPolygon struct {
+	Outer kmlLinearRing   `xml:"outerBoundaryIs>LinearRing"`
+	Inner []kmlLinearRing `xml:"innerBoundaryIs>LinearRing,omitempty"`
+}
+
+type kmlLinearRing struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlMultiGeometry struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+type kmlTrack struct {
+	When  []string `xml:"when"`
+	Coord []string `xml:"gx:coord"`
+}
+
+// kmlStyle is the styling applied to all placemarks of a KML document.
+// Colors are given as hex rrggbb or rrggbbaa, the width in pixels.
+type kmlStyle struct {
+	LineColor string
+	LineWidth float64
+	FillColor string
+}
+
+// kmlStyleFromQuery reads the optional query parameters lineColor, lineWidth and fillColor.
+func kmlStyleFromQuery(c *gin.Context) (kmlStyle, error) {
+	style := kmlStyle{LineColor: "ff0000", LineWidth: 3, FillColor: "0000ff80"}
+	for param, color := range map[string]*string{"lineColor": &style.LineColor, "fillColor": &style.FillColor} {
+		if value := strings.TrimPrefix(c.Query(param), "#"); value != "" {
+			if _, err := kmlColor(value); err != nil {
+				return style, fmt.Errorf("%s: %v", param, err)
+			}
+			*color = value
+		}
+	}
+	if value := c.Query("lineWidth"); value != "" {
+		width, err := strconv.ParseFloat(value, 64)
+		if err != nil || width <= 0 {
+			return style, errors.New("lineWidth must be a positive number")
+		}
+		style.LineWidth = width
+	}
+	return style, nil
+}
+
+// kmlColor converts a hex rrggbb or rrggbbaa color into the aabbggrr notation of KML.
+func kmlColor(color string) (string, error) {
+	if len(color) == 6 {
+		color += "ff"
+	}
+	if _, err := hex.DecodeString(color); err != nil || len(color) != 8 {
+		return "", errors.New("color must be given as hex rrggbb or rrggbbaa")
+	}
+	color = strings.ToLower(color)
+	return color[6:8] + color[4:6] + color[2:4] + color[0:2], nil
+}
+
+// newKML converts GeoJSON features into a KML document. See newKMLPlacemark for the conversion of a single feature.
+func newKML(name string, style kmlStyle, features []*geojson.Feature) (kml, error) {
+	lineColor, err := kmlColor(style.LineColor)
+	if err != nil {
+		return kml{}, err
+	}
+	fillColor, err := kmlColor(style.FillColor)
+	if err != nil {
+		return kml{}, err
+	}
+	doc := kml{
+		Xmlns:   kmlNamespace,
+		XmlnsGx: kmlGxNamespace,
+		Document: kmlDocument{
+			Name: name,
+			Style: kmlStyleXML{
+				ID:        kmlStyleID,
+				LineColor: lineColor,
+				LineWidth: strconv.FormatFloat(style.LineWidth, 'f', -1, 64),
+				PolyColor: fillColor,
+			},
+			Placemarks: make([]kmlPlacemark, 0, len(features)),
+		},
+	}
+	for _, feature := range features {
+		placemark, err := newKMLPlacemark(feature)
+		if err != nil {
+			return kml{}, err
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, placemark)
+	}
+	return doc, nil
+}
+
+// newKMLPlacemark converts a GeoJSON feature into a KML placemark. The property "name" becomes the name of
+// the placemark, the remaining scalar properties are kept as extended data. Features with a "timestamps"
+// property, like trajectories, become a gx:Track. Otherwise Point, LineString, Polygon and MultiPolygon
+// geometries are supported.
+func newKMLPlacemark(feature *geojson.Feature) (kmlPlacemark, error) {
+	placemark := kmlPlacemark{StyleURL: "#" + kmlStyleID}
+	keys := make([]string, 0, len(feature.Properties))
+	for key := range feature.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := feature.Properties[key]
+		if key == "name" {
+			placemark.Name = fmt.Sprint(value)
+			continue
+		}
+		switch value := value.(type) {
+		case string, bool, int, int64, float64:
+			placemark.ExtendedData = append(placemark.ExtendedData, kmlData{Name: key, Value: fmt.Sprint(value)})
+		case time.Time:
+			placemark.ExtendedData = append(placemark.ExtendedData, kmlData{Name: key, Value: value.UTC().Format(time.RFC3339)})
+		}
+	}
+
+	if timestamps, ok := feature.Properties["timestamps"].([]time.Time); ok {
+		var points []orb.Point
+		switch g := feature.Geometry.(type) {
+		case orb.Point:
+			points = []orb.Point{g}
+		case orb.LineString:
+			points = g
+		}
+		if len(points) != len(timestamps) {
+			return placemark, errors.New("number of timestamps does not match number of points")
+		}
+		track := &kmlTrack{When: make([]string, len(points)), Coord: make([]string, len(points))}
+		for i, p := range points {
+			track.When[i] = timestamps[i].UTC().Format(time.RFC3339)
+			track.Coord[i] = strconv.FormatFloat(p.Lon(), 'f', -1, 64) + " " + strconv.FormatFloat(p.Lat(), 'f', -1, 64) + " 0"
+		}
+		placemark.Track = track
+		return placemark, nil
+	}
+
+	switch g := feature.Geometry.(type) {
+	case nil:
+	case orb.Point:
+		placemark.Point = &kmlPoint{Coordinates: kmlCoordinates([]orb.Point{g})}
+	case orb.LineString:
+		placemark.LineString = &kmlLineString{Coordinates: kmlCoordinates(g)}
+	case orb.Polygon:
+		polygon := newKMLPolygon(g)
+		placemark.Polygon = &polygon
+	case orb.MultiPolygon:
+		multi := &kmlMultiGeometry{Polygons: make([]kmlPolygon, len(g))}
+		for i, polygon := range g {
+			multi.Polygons[i] = newKMLPolygon(polygon)
+		}
+		placemark.MultiGeometry = multi
+	default:
+		return placemark, fmt.Errorf("unsupported geometry %s", g.GeoJSONType())
+	}
+	return placemark, nil
+}
+
+func newKMLPolygon(polygon orb.Polygon) kmlPolygon {
+	result := kmlPolygon{}
+	for i, ring := range polygon {
+		if i == 0 {
+			result.Outer = kmlLinearRing{Coordinates: kmlCoordinates(ring)}
+		} else {
+			result.Inner = append(result.Inner, kmlLinearRing{Coordinates: kmlCoordinates(ring)})
+		}
+	}
+	return result
+}
+
+// kmlCoordinates formats points as lon,lat tuples separated by spaces.
+func kmlCoordinates(points []orb.Point) string {
+	tuples := make([]string, len(points))
+	for i, p := range points {
+		tuples[i] = strconv.FormatFloat(p.Lon(), 'f', -1, 64) + "," + strconv.FormatFloat(p.Lat(), 'f', -1, 64)
+	}
+	return strings.Join(tuples, " ")
+}
+
+// writeKML writes doc as indented XML, including the XML declaration.
+func writeKML(w io.Writer, doc kml) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
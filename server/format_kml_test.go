@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestKMLColor(t *testing.T) {
+	var tests = []struct {
+		color    string
+		expected string
+		valid    bool
+	}{
+		{"ff0000", "ff0000ff", true},
+		{"FF000080", "800000ff", true},
+		{"12345", "", false},
+		{"gg0000", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.color, func(t *testing.T) {
+			// action
+			result, err := kmlColor(test.color)
+			// verify
+			if !test.valid {
+				verify.Assert(t, err != nil, "expected error")
+				return
+			}
+			verify.Ok(t, err)
+			verify.Equals(t, test.expected, result)
+		})
+	}
+}
+
+func TestKMLStyleFromQuery(t *testing.T) {
+	t.Run("custom", func(t *testing.T) {
+		// arrange
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?lineColor=%2300ff00&lineWidth=5&fillColor=00ff0040", nil)
+		// action
+		result, err := kmlStyleFromQuery(c)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, kmlStyle{LineColor: "00ff00", LineWidth: 5, FillColor: "00ff0040"}, result)
+	})
+
+	for _, query := range []string{"?lineColor=red", "?fillColor=1", "?lineWidth=0", "?lineWidth=thick"} {
+		t.Run(query, func(t *testing.T) {
+			// arrange
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/"+query, nil)
+			// action
+			_, err := kmlStyleFromQuery(c)
+			// verify
+			verify.Assert(t, err != nil, "expected error")
+		})
+	}
+}
+
+func TestNewKML(t *testing.T) {
+	// arrange
+	states := []vehicleState{
+		{VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)},
+		{VehicleID: 7, Position: *geojson.NewGeometry(orb.Point{20.5, 30}), Timestamp: time.Date(2021, 6, 15, 9, 1, 0, 0, time.UTC)},
+	}
+	trajectory := newTrajectory(7, states)
+	trajectory.Properties["name"] = "truck"
+	depot := newGeofenceFeature(geofence{
+		ID:       1,
+		Name:     "depot",
+		Category: "yard",
+		Area: *geojson.NewGeometry(orb.Polygon{
+			{{19, 29}, {21, 29}, {21, 31}, {19, 31}, {19, 29}},
+			{{19.5, 29.5}, {20.5, 29.5}, {20.5, 30.5}, {19.5, 29.5}},
+		}),
+	})
+	style := kmlStyle{LineColor: "ff0000", LineWidth: 3, FillColor: "0000ff80"}
+	var buf bytes.Buffer
+	// action
+	doc, err := newKML("test", style, []*geojson.Feature{trajectory, depot})
+	verify.Ok(t, err)
+	err = writeKML(&buf, doc)
+	// verify
+	verify.Ok(t, err)
+	result := buf.String()
+	for _, expected := range []string{
+		`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">`,
+		`<color>ff0000ff</color>`,
+		`<color>80ff0000</color>`,
+		`<name>truck</name>`,
+		`<when>2021-06-15T09:01:00Z</when>`,
+		`<gx:coord>20.5 30 0</gx:coord>`,
+		`<Data name="vehicleId">`,
+		`<name>depot</name>`,
+		`<coordinates>19,29 21,29 21,31 19,31 19,29</coordinates>`,
+		`<innerBoundaryIs>`,
+		`<Data name="category">`,
+	} {
+		verify.Assert(t, strings.Contains(result, expected), "%s missing: %s", expected, result)
+	}
+}
+
+func TestNewKMLPlacemark(t *testing.T) {
+	t.Run("multi polygon", func(t *testing.T) {
+		// arrange
+		ring := orb.Ring{{19, 29}, {21, 29}, {21, 31}, {19, 29}}
+		feature := geojson.NewFeature(orb.MultiPolygon{{ring}, {ring}})
+		// action
+		result, err := newKMLPlacemark(feature)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result.MultiGeometry.Polygons))
+	})
+
+	t.Run("unsupported geometry", func(t *testing.T) {
+		// action
+		_, err := newKMLPlacemark(geojson.NewFeature(orb.MultiPoint{{20, 30}}))
+		// verify
+		verify.Assert(t, err != nil, "expected error")
+	})
+}
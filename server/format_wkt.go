@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// parseWKT reads a Point, LineString, Polygon or MultiPolygon given as well-known text,
+// e.g. "POINT(20 30)". Keywords are case insensitive, coordinates with Z or M values and
+// EMPTY geometries are not supported. The parser of orb/encoding/wkt is not used, as it
+// merges the rings of polygons with holes.
+func parseWKT(s string) (orb.Geometry, error) {
+	p := &wktParser{s: s}
+	keyword := strings.ToUpper(p.keyword())
+	var g orb.Geometry
+	var err error
+	switch keyword {
+	case "POINT":
+		var points []orb.Point
+		points, err = p.points()
+		if err == nil && len(points) != 1 {
+			err = errors.New("wkt: point must have a single coordinate")
+		}
+		if err == nil {
+			g = points[0]
+		}
+	case "LINESTRING":
+		var points []orb.Point
+		points, err = p.points()
+		g = orb.LineString(points)
+	case "POLYGON":
+		g, err = p.polygon()
+	case "MULTIPOLYGON":
+		var polygons orb.MultiPolygon
+		err = p.list(func() error {
+			polygon, err := p.polygon()
+			polygons = append(polygons, polygon)
+			return err
+		})
+		g = polygons
+	case "":
+		err = errors.New("wkt: geometry type expected")
+	default:
+		err = fmt.Errorf("wkt: unsupported geometry type %s", keyword)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("wkt: unexpected %q at offset %d", p.s[p.pos:], p.pos)
+	}
+	return g, nil
+}
+
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips c and returns true if it is the next character.
+func (p *wktParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *wktParser) keyword() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z' || p.s[p.pos] >= 'A' && p.s[p.pos] <= 'Z') {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *wktParser) number() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && strings.ContainsRune("0123456789+-.eE", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("wkt: number expected at offset %d", start)
+	}
+	return strconv.ParseFloat(p.s[start:p.pos], 64)
+}
+
+// list reads a parenthesized, comma separated list, calling item for each element.
+func (p *wktParser) list(item func() error) error {
+	if !p.consume('(') {
+		return fmt.Errorf("wkt: ( expected at offset %d", p.pos)
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if p.consume(')') {
+			return nil
+		}
+		if !p.consume(',') {
+			return fmt.Errorf("wkt: , or ) expected at offset %d", p.pos)
+		}
+	}
+}
+
+func (p *wktParser) points() ([]orb.Point, error) {
+	var points []orb.Point
+	err := p.list(func() error {
+		x, err := p.number()
+		if err != nil {
+			return err
+		}
+		y, err := p.number()
+		if err != nil {
+			return err
+		}
+		points = append(points, orb.Point{x, y})
+		return nil
+	})
+	return points, err
+}
+
+func (p *wktParser) polygon() (orb.Polygon, error) {
+	var polygon orb.Polygon
+	err := p.list(func() error {
+		ring, err := p.points()
+		polygon = append(polygon, ring)
+		return err
+	})
+	return polygon, err
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+)
+
+func TestParseWKT(t *testing.T) {
+	var tests = []struct {
+		wkt      string
+		expected orb.Geometry
+	}{
+		{"POINT(20 30)", orb.Point{20, 30}},
+		{" point ( -20.5  3e1 ) ", orb.Point{-20.5, 30}},
+		{"LINESTRING(20 30, 21 31)", orb.LineString{{20, 30}, {21, 31}}},
+		{
+			"POLYGON((19 29, 21 29, 21 31, 19 29), (19.5 29.5, 20 29.5, 20 30, 19.5 29.5))",
+			orb.Polygon{{{19, 29}, {21, 29}, {21, 31}, {19, 29}}, {{19.5, 29.5}, {20, 29.5}, {20, 30}, {19.5, 29.5}}},
+		},
+		{
+			"MULTIPOLYGON(((19 29,21 29,21 31,19 29)),((0 0,1 0,1 1,0 0)))",
+			orb.MultiPolygon{{{{19, 29}, {21, 29}, {21, 31}, {19, 29}}}, {{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.wkt, func(t *testing.T) {
+			// action
+			result, err := parseWKT(test.wkt)
+			// verify
+			verify.Ok(t, err)
+			verify.Equals(t, test.expected, result)
+		})
+	}
+
+	for _, wkt := range []string{"", "POINT", "POINT EMPTY", "POINT(20)", "POINT(20 30 40)", "POINT(20 30, 21 31)", "POINT(20 30))", "POINT(20 x)", "CIRCLE(1 2)", "POLYGON(19 29)"} {
+		t.Run("invalid "+wkt, func(t *testing.T) {
+			// action
+			_, err := parseWKT(wkt)
+			// verify
+			verify.Assert(t, err != nil, "expected error")
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// listenMinBackoff is the delay before reconnecting a lost listener, doubled on each failed attempt.
+	listenMinBackoff = time.Second
+	// listenMaxBackoff is the longest delay between attempts to reconnect.
+	listenMaxBackoff = 30 * time.Second
+	// listenBatchSize is the number of states loaded at once.
+	listenBatchSize = 500
+	// listenPollInterval is the interval of checking for commits without notification. A commit only reaches
+	// commitHorizon once all older transactions have ended, which may be after its notification was received.
+	listenPollInterval = time.Second
+)
+
+// listenForUpdates publishes the vehicle states committed by any server instance sharing the database,
+// see notifyVehicleStates, to the local broker in commit order until ctx is done. The listener uses a
+// dedicated connection, which is reconnected with exponential backoff if lost. States committed while
+// reconnecting are published from the commit log before any further notification, see publishCommitted.
+func (srv ApplicationServer) listenForUpdates(ctx context.Context) {
+	backoff := listenMinBackoff
+	var last *commitCursor // not known before the first connection
+	for {
+		conn, err := srv.listen(ctx)
+		if err == nil {
+			backoff = listenMinBackoff
+			if last == nil {
+				var cursor commitCursor
+				cursor, err = getLastCommitCursor(srv.logger, srv.db)
+				if err == nil {
+					last = &cursor
+					srv.broker.advance(cursor)
+				}
+			}
+			if err == nil {
+				err = srv.receiveUpdates(ctx, conn, last)
+			}
+			conn.Close(context.Background())
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		srv.logger.Printf("Listening for vehicle states failed, retrying in %v: %v\n", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > listenMaxBackoff {
+			backoff = listenMaxBackoff
+		}
+	}
+}
+
+// listen opens a connection outside of the pool, which is listening for vehicle states and replay sessions.
+func (srv ApplicationServer) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, srv.db.Config().ConnConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = listenVehicleStates(srv.logger, conn)
+	if err == nil {
+		err = listenReplaySessions(srv.logger, conn)
+	}
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+// receiveUpdates publishes the states committed after last, and again whenever a commit is announced on conn
+// or listenPollInterval has passed, until ctx is done or conn fails. Replay sessions announced on conn are
+// reloaded, see reloadReplay. It has to be called after listening, so that nothing is missed in between.
+// If the states cannot be loaded, an error is returned and they are published after reconnecting.
+func (srv ApplicationServer) receiveUpdates(ctx context.Context, conn *pgx.Conn, last *commitCursor) error {
+	for _, id := range srv.replays.ids() {
+		srv.reloadReplay(id)
+	}
+	for {
+		err := srv.publishCommitted(last)
+		if err != nil {
+			return err
+		}
+		wait, cancel := context.WithTimeout(ctx, listenPollInterval)
+		notification, err := conn.WaitForNotification(wait)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && wait.Err() == nil {
+			return err
+		}
+		if err == nil && notification.Channel == channelReplaySessions {
+			srv.reloadReplay(notification.Payload)
+		}
+	}
+}
+
+// publishCommitted publishes the states committed after last along with their geofence events
+// and advances last to the last state published.
+func (srv ApplicationServer) publishCommitted(last *commitCursor) error {
+	for {
+		states, err := getVehicleStatesCommittedAfter(srv.logger, srv.db, *last, listenBatchSize)
+		if err != nil || len(states) == 0 {
+			return err
+		}
+		ids := make([]int64, len(states))
+		for i, state := range states {
+			ids[i] = state.ID
+		}
+		events, err := getGeofenceEventsOfVehicleStates(srv.logger, srv.db, ids)
+		if err != nil {
+			return err
+		}
+		srv.broker.publish(states, events)
+		*last = states[len(states)-1].Cursor
+		if len(states) < listenBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestListenForUpdatesIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	ingest := NewApplicationServer(db, ":5001")
+	ingest.CreateDatabaseStructure()
+	unit := NewApplicationServer(db, ":5002")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go unit.listenForUpdates(ctx)
+	vehicleID, _ := addVehicle(ingest.logger, ingest.db, vehicle{Name: "truck"})
+	sub := newStateSubscription(subscriptionFilter{VehicleIDs: []int64{vehicleID}}, streamBufferSize)
+	unit.broker.subscribe(sub)
+
+	// awaitState stores states with ingest until one is published by unit, the listener may still be connecting.
+	awaitState := func(t *testing.T) vehicleState {
+		retry := time.NewTicker(100 * time.Millisecond)
+		defer retry.Stop()
+		timeout := time.After(10 * time.Second)
+		for {
+			_, err := addVehicleState(ingest.logger, ingest.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Now()})
+			verify.Ok(t, err)
+			select {
+			case state := <-sub.states:
+				return state
+			case <-retry.C:
+			case <-timeout:
+				t.Fatal("no state received")
+			}
+		}
+	}
+
+	t.Run("Receiving states stored by another instance", func(t *testing.T) {
+		// action
+		state := awaitState(t)
+		// verify
+		verify.Equals(t, vehicleID, state.VehicleID)
+		verify.Equals(t, orb.Point{20, 30}, state.Position.Geometry())
+	})
+
+	t.Run("Reconnecting lost listener", func(t *testing.T) {
+		// arrange
+		_, err := db.Exec(
+			context.Background(),
+			`SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE query = $1`,
+			"LISTEN "+channelVehicleStates,
+		)
+		verify.Ok(t, err)
+		// action
+		state := awaitState(t)
+		// verify
+		verify.Equals(t, vehicleID, state.VehicleID)
+	})
+
+	t.Run("Publishing states stored while reconnecting", func(t *testing.T) {
+		// arrange
+		_, err := db.Exec(
+			context.Background(),
+			`SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE query = $1`,
+			"LISTEN "+channelVehicleStates,
+		)
+		verify.Ok(t, err)
+		// action
+		id, err := addVehicleState(ingest.logger, ingest.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 31}), Timestamp: time.Now()})
+		verify.Ok(t, err)
+		// verify, states stored by awaitState before may still be queued
+		timeout := time.After(10 * time.Second)
+		for received := false; !received; {
+			select {
+			case state := <-sub.states:
+				received = state.ID == id
+			case <-timeout:
+				t.Fatal("no state received")
+			}
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Types of messages exchanged on live connections.
+const (
+	liveSubscribe     = "subscribe"
+	liveUnsubscribe   = "unsubscribe"
+	liveSubscribed    = "subscribed"
+	liveUnsubscribed  = "unsubscribed"
+	liveVehicleState  = "vehicleState"
+	liveGeofenceEvent = "geofenceEvent"
+	liveError         = "error"
+)
+
+const (
+	// liveMaxPendingMessages is the number of replies and geofence events queued for a connection before it is closed.
+	liveMaxPendingMessages = 1024
+	// liveMaxSubscriptions is the number of subscriptions a connection may hold.
+	liveMaxSubscriptions = 64
+)
+
+// liveRequest is a message sent by the client of a live connection. Subscribe creates or
+// replaces the subscription with the given id, unsubscribe removes it.
+type liveRequest struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	VehicleIDs []int64   `json:"vehicleIds"`
+	BBox       []float64 `json:"bbox"`
+}
+
+// liveMessage is a message sent to the client of a live connection. Vehicle states and geofence events
+// list the ids of the subscriptions they match.
+type liveMessage struct {
+	Type          string         `json:"type"`
+	ID            string         `json:"id,omitempty"`
+	Subscriptions []string       `json:"subscriptions,omitempty"`
+	VehicleState  interface{}    `json:"vehicleState,omitempty"`
+	GeofenceEvent *geofenceEvent `json:"geofenceEvent,omitempty"`
+	Error         string         `json:"error,omitempty"`
+
+	// state is encoded into VehicleState when the message is written.
+	state *vehicleState
+}
+
+// liveConnection is a subscriber queueing the updates for a live connection until they are written.
+// Only the newest position of each vehicle is queued, so that slow clients skip intermediate positions
+// instead of falling behind. Geofence events are not skipped, the connection is closed if too many are queued.
+type liveConnection struct {
+	mu            sync.Mutex
+	subscriptions map[string]subscriptionFilter
+	messages      []liveMessage
+	positions     []liveMessage
+	// positionIndex maps vehicle ids to their queued position
+	positionIndex map[int64]int
+	closed        bool
+
+	// wake is signalled when messages are queued, done is closed when the connection is closed.
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newLiveConnection() *liveConnection {
+	return &liveConnection{
+		subscriptions: map[string]subscriptionFilter{},
+		positionIndex: map[int64]int{},
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// handle applies a request of the client and queues the reply.
+func (l *liveConnection) handle(req liveRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reply := liveMessage{ID: req.ID}
+	if err := l.apply(req); err != nil {
+		reply.Type, reply.Error = liveError, err.Error()
+	} else if req.Type == liveSubscribe {
+		reply.Type = liveSubscribed
+	} else {
+		reply.Type = liveUnsubscribed
+	}
+	l.queue(reply)
+}
+
+// reject queues an error reply for a request that could not be read.
+func (l *liveConnection) reject(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queue(liveMessage{Type: liveError, Error: err.Error()})
+}
+
+// queue adds a reply, l.mu must be held.
+func (l *liveConnection) queue(msg liveMessage) {
+	l.messages = append(l.messages, msg)
+	l.signal()
+}
+
+func (l *liveConnection) apply(req liveRequest) error {
+	if req.ID == "" {
+		return errors.New("id is required")
+	}
+	switch req.Type {
+	case liveSubscribe:
+		filter := subscriptionFilter{VehicleIDs: req.VehicleIDs}
+		if req.BBox != nil {
+			if len(req.BBox) != 4 {
+				return errors.New("bbox must be [minLon, minLat, maxLon, maxLat]")
+			}
+			bbox, err := newBBox([4]float64{req.BBox[0], req.BBox[1], req.BBox[2], req.BBox[3]})
+			if err != nil {
+				return err
+			}
+			filter.BBox = &bbox
+		}
+		if _, ok := l.subscriptions[req.ID]; !ok && len(l.subscriptions) >= liveMaxSubscriptions {
+			return errors.New("too many subscriptions")
+		}
+		l.subscriptions[req.ID] = filter
+	case liveUnsubscribe:
+		if _, ok := l.subscriptions[req.ID]; !ok {
+			return errors.New("unknown subscription")
+		}
+		delete(l.subscriptions, req.ID)
+	default:
+		return errors.New("type must be subscribe or unsubscribe")
+	}
+	return nil
+}
+
+// matching returns the sorted ids of the subscriptions matching the state.
+func (l *liveConnection) matching(state vehicleState) []string {
+	var ids []string
+	for id, filter := range l.subscriptions {
+		if filter.matches(state) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (l *liveConnection) deliver(states []vehicleState, events []geofenceEvent) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.subscriptions) == 0 {
+		return true
+	}
+	queued := false
+	causes := make(map[int64]vehicleState, len(states))
+	for _, state := range states {
+		causes[state.ID] = state
+		ids := l.matching(state)
+		if len(ids) == 0 {
+			continue
+		}
+		state := state
+		msg := liveMessage{Type: liveVehicleState, Subscriptions: ids, state: &state}
+		if i, ok := l.positionIndex[state.VehicleID]; ok {
+			// the client is behind, skip the intermediate position
+			if !state.Timestamp.Before(l.positions[i].state.Timestamp) {
+				l.positions[i] = msg
+			}
+		} else {
+			l.positionIndex[state.VehicleID] = len(l.positions)
+			l.positions = append(l.positions, msg)
+		}
+		queued = true
+	}
+	for _, event := range events {
+		cause, ok := causes[event.VehicleStateID]
+		if !ok {
+			continue
+		}
+		ids := l.matching(cause)
+		if len(ids) == 0 {
+			continue
+		}
+		event := event
+		l.messages = append(l.messages, liveMessage{Type: liveGeofenceEvent, Subscriptions: ids, GeofenceEvent: &event})
+		queued = true
+	}
+	if len(l.messages) > liveMaxPendingMessages {
+		return false
+	}
+	if queued {
+		l.signal()
+	}
+	return true
+}
+
+// signal wakes up the writer, l.mu must be held.
+func (l *liveConnection) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns and removes all queued messages, replies and events first.
+func (l *liveConnection) take() []liveMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	messages := append(l.messages, l.positions...)
+	l.messages, l.positions = nil, nil
+	l.positionIndex = map[int64]int{}
+	return messages
+}
+
+func (l *liveConnection) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+)
+
+func TestLiveConnection(t *testing.T) {
+	t.Run("replies to requests", func(t *testing.T) {
+		// arrange
+		unit := newLiveConnection()
+		// action
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "a", VehicleIDs: []int64{1}})
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "b", BBox: []float64{20, 30, 10, 40}})
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "c", BBox: []float64{20, 30}})
+		unit.handle(liveRequest{Type: liveUnsubscribe, ID: "a"})
+		unit.handle(liveRequest{Type: liveUnsubscribe, ID: "d"})
+		unit.handle(liveRequest{Type: "publish", ID: "e"})
+		unit.handle(liveRequest{Type: liveSubscribe})
+		// verify
+		var types []string
+		for _, msg := range unit.take() {
+			types = append(types, msg.Type+" "+msg.ID)
+		}
+		verify.Equals(t, []string{
+			"subscribed a", "subscribed b", "error c", "unsubscribed a", "error d", "error e", "error ",
+		}, types)
+		verify.Equals(t, 1, len(unit.subscriptions))
+	})
+
+	t.Run("coalesces positions", func(t *testing.T) {
+		// arrange
+		unit := newLiveConnection()
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "a"})
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "b", VehicleIDs: []int64{2}})
+		unit.take()
+		// action
+		unit.deliver([]vehicleState{brokerTestState(1, 1, orb.Point{20, 30}), brokerTestState(2, 2, orb.Point{20, 30})}, nil)
+		unit.deliver([]vehicleState{brokerTestState(3, 1, orb.Point{21, 30})}, nil)
+		// verify
+		messages := unit.take()
+		verify.Equals(t, 2, len(messages))
+		verify.Equals(t, int64(3), messages[0].state.ID)
+		verify.Equals(t, []string{"a"}, messages[0].Subscriptions)
+		verify.Equals(t, int64(2), messages[1].state.ID)
+		verify.Equals(t, []string{"a", "b"}, messages[1].Subscriptions)
+		verify.Equals(t, 0, len(unit.take()))
+	})
+
+	t.Run("delivers events of matching states", func(t *testing.T) {
+		// arrange
+		unit := newLiveConnection()
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "a", BBox: []float64{19, 29, 21, 31}})
+		unit.take()
+		// action
+		unit.deliver(
+			[]vehicleState{brokerTestState(1, 1, orb.Point{20, 30}), brokerTestState(2, 2, orb.Point{25, 30})},
+			[]geofenceEvent{{ID: 7, VehicleStateID: 1, Type: geofenceEventEnter}, {ID: 8, VehicleStateID: 2, Type: geofenceEventEnter}},
+		)
+		// verify
+		messages := unit.take()
+		verify.Equals(t, 2, len(messages))
+		verify.Equals(t, liveGeofenceEvent, messages[0].Type)
+		verify.Equals(t, int64(7), messages[0].GeofenceEvent.ID)
+		verify.Equals(t, liveVehicleState, messages[1].Type)
+	})
+
+	t.Run("lagging connection is dropped", func(t *testing.T) {
+		// arrange
+		unit := newLiveConnection()
+		unit.handle(liveRequest{Type: liveSubscribe, ID: "a"})
+		unit.take()
+		events := make([]geofenceEvent, liveMaxPendingMessages+1)
+		for i := range events {
+			events[i] = geofenceEvent{ID: int64(i), VehicleStateID: 1}
+		}
+		// action
+		ok := unit.deliver([]vehicleState{brokerTestState(1, 1, orb.Point{20, 30})}, events)
+		// verify
+		verify.Assert(t, !ok, "lagging connection is kept")
+	})
+
+	t.Run("ignores updates without subscriptions", func(t *testing.T) {
+		// arrange
+		unit := newLiveConnection()
+		// action
+		ok := unit.deliver([]vehicleState{brokerTestState(1, 1, orb.Point{20, 30})}, nil)
+		// verify
+		verify.Assert(t, ok, "connection is dropped")
+		verify.Equals(t, 0, len(unit.take()))
+	})
+}
@@ -1,14 +1,151 @@
 package server
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/paulmach/orb/geojson"
 )
 
+type vehicle struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name" binding:"required"`
+}
+
+// vehicleState is a position reported by a vehicle. All telemetry fields are optional.
 type vehicleState struct {
+	ID        int64            `json:"id,omitempty"`
+	VehicleID int64            `json:"vehicleId" binding:"required"`
 	Position  geojson.Geometry `json:"position"`
 	Timestamp time.Time        `json:"timestamp"`
+	// Speed over ground in m/s.
+	Speed *float64 `json:"speed,omitempty" binding:"omitempty,gte=0"`
+	// Heading in degrees clockwise from north.
+	Heading *float64 `json:"heading,omitempty" binding:"omitempty,gte=0,lt=360"`
+	// Altitude in metres above sea level.
+	Altitude *float64 `json:"altitude,omitempty"`
+	// Accuracy is the horizontal accuracy of Position in metres.
+	Accuracy *float64 `json:"accuracy,omitempty" binding:"omitempty,gte=0"`
+	// Odometer is the total distance driven in metres, as reported by the vehicle.
+	Odometer *float64 `json:"odometer,omitempty" binding:"omitempty,gte=0"`
+	Ignition *bool    `json:"ignition,omitempty"`
+	// Attributes holds any further sensor readings.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// Cursor orders states by the commit of the transaction storing them. It is only set for states
+	// loaded by forEachVehicleStateCommittedAfter.
+	Cursor commitCursor `json:"-"`
+}
+
+// UnmarshalJSON reads a vehicle state whose position is given as GeoJSON geometry,
+// WKT or hex encoded WKB, see decodeGeometry.
+func (state *vehicleState) UnmarshalJSON(data []byte) error {
+	type plain vehicleState
+	aux := struct {
+		*plain
+		Position json.RawMessage `json:"position"`
+	}{plain: (*plain)(state)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	state.Position, err = decodeGeometry(aux.Position)
+	return err
+}
+
+// nearbyVehicleState is a vehicle state together with its distance
+// in metres to a search location.
+type nearbyVehicleState struct {
+	vehicleState
+	Distance float64 `json:"distance"`
+}
+
+// UnmarshalJSON replaces the method promoted from vehicleState, which would skip Distance.
+func (state *nearbyVehicleState) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		Distance float64 `json:"distance"`
+	}{}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	state.Distance = aux.Distance
+	return json.Unmarshal(data, &state.vehicleState)
+}
+
+// latestVehicleState is the newest known state of a vehicle.
+// Stale is set if the vehicle has not reported for a configured duration.
+type latestVehicleState struct {
+	vehicleState
+	Stale bool `json:"stale"`
+}
+
+// UnmarshalJSON replaces the method promoted from vehicleState, which would skip Stale.
+func (state *latestVehicleState) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		Stale bool `json:"stale"`
+	}{}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	state.Stale = aux.Stale
+	return json.Unmarshal(data, &state.vehicleState)
+}
+
+// geofence is a named area, e.g. a depot or a restricted zone.
+// Its area is either a Polygon or a MultiPolygon.
+type geofence struct {
+	ID       int64            `json:"id"`
+	Name     string           `json:"name" binding:"required"`
+	Category string           `json:"category"`
+	Area     geojson.Geometry `json:"area"`
+}
+
+// UnmarshalJSON reads a geofence whose area is given as GeoJSON geometry,
+// WKT or hex encoded WKB, see decodeGeometry.
+func (fence *geofence) UnmarshalJSON(data []byte) error {
+	type plain geofence
+	aux := struct {
+		*plain
+		Area json.RawMessage `json:"area"`
+	}{plain: (*plain)(fence)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	fence.Area, err = decodeGeometry(aux.Area)
+	return err
+}
+
+// geofenceEvent records a vehicle entering or leaving a geofence.
+type geofenceEvent struct {
+	ID             int64     `json:"id"`
+	GeofenceID     int64     `json:"geofenceId"`
+	VehicleID      int64     `json:"vehicleId"`
+	VehicleStateID int64     `json:"vehicleStateId"`
+	Type           string    `json:"type"`
+	Timestamp      time.Time `json:"timestamp"`
+	// Dwell is the time in seconds spent inside the geofence since the
+	// preceding enter event. It is only set for exit events.
+	Dwell *float64 `json:"dwell,omitempty"`
+}
+
+// trip is a segment of the history of a vehicle, either a trip between two stops or a stop.
+type trip struct {
+	ID        int64     `json:"id"`
+	VehicleID int64     `json:"vehicleId"`
+	Type      string    `json:"type"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// StartPosition and EndPosition are the first and last reported positions.
+	StartPosition geojson.Geometry `json:"startPosition"`
+	EndPosition   geojson.Geometry `json:"endPosition"`
+	// Path is the LineString driven for trips, the location as Point for stops.
+	Path geojson.Geometry `json:"path"`
+	// Distance in metres along Path.
+	Distance float64 `json:"distance"`
+	// Duration in seconds.
+	Duration float64 `json:"duration"`
 }
 
 type user struct {
@@ -13,6 +13,7 @@ import (
 func TestVehicleStateToJson(t *testing.T) {
 	// arrange
 	unit := vehicleState{
+		VehicleID: 1,
 		Position:  *geojson.NewGeometry(orb.Point([2]float64{20, 30})),
 		Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC),
 	}
@@ -20,13 +21,14 @@ func TestVehicleStateToJson(t *testing.T) {
 	result, err := json.Marshal(unit)
 	// verify
 	verify.Ok(t, err)
-	verify.Equals(t, "{\"position\":{\"type\":\"Point\",\"coordinates\":[20,30]},\"timestamp\":\"2021-06-15T09:00:00Z\"}", string(result))
+	verify.Equals(t, "{\"vehicleId\":1,\"position\":{\"type\":\"Point\",\"coordinates\":[20,30]},\"timestamp\":\"2021-06-15T09:00:00Z\"}", string(result))
 }
 
 func TestJsonToVehicleState(t *testing.T) {
 	// arrange
 	testdata := `
 	{
+		"vehicleId": 1,
 		"timestamp": "2021-06-15T09:00:00Z",
 		"position": {
 			"type": "Point",
@@ -42,6 +44,7 @@ func TestJsonToVehicleState(t *testing.T) {
 	err := json.Unmarshal([]byte(testdata), &result)
 	// verify
 	verify.Ok(t, err)
+	verify.Equals(t, int64(1), result.VehicleID)
 	point := result.Position.Geometry().(orb.Point)
 	verify.Condition(t, point.X()-20.0 < 0.1)
 	verify.Condition(t, point.Y()-30.0 < 0.1)
@@ -50,3 +53,66 @@ func TestJsonToVehicleState(t *testing.T) {
 	verify.Condition(t, result.Timestamp.Day() == 15)
 	verify.Condition(t, result.Timestamp.Hour() == 9)
 }
+
+func TestJsonToVehicleStateWithTelemetry(t *testing.T) {
+	// arrange
+	testdata := `
+	{
+		"vehicleId": 1,
+		"timestamp": "2021-06-15T09:00:00Z",
+		"position": {"type": "Point", "coordinates": [20, 30]},
+		"speed": 12.5,
+		"heading": 270,
+		"ignition": false,
+		"attributes": {"fuel": 0.5, "door": "closed"}
+	}
+	`
+	// action
+	var result vehicleState
+	err := json.Unmarshal([]byte(testdata), &result)
+	// verify
+	verify.Ok(t, err)
+	verify.Equals(t, 12.5, *result.Speed)
+	verify.Equals(t, 270.0, *result.Heading)
+	verify.Equals(t, false, *result.Ignition)
+	verify.Assert(t, result.Altitude == nil, "altitude is set")
+	verify.Equals(t, map[string]interface{}{"fuel": 0.5, "door": "closed"}, result.Attributes)
+}
+
+func TestJsonToVehicleStateWithWKT(t *testing.T) {
+	// arrange
+	testdata := `{"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z", "position": "POINT(20 30)", "speed": 12.5}`
+	// action
+	var result vehicleState
+	err := json.Unmarshal([]byte(testdata), &result)
+	// verify
+	verify.Ok(t, err)
+	verify.Equals(t, int64(1), result.VehicleID)
+	verify.Equals(t, orb.Point{20, 30}, result.Position.Geometry())
+	verify.Equals(t, 12.5, *result.Speed)
+}
+
+func TestJsonToNearbyVehicleState(t *testing.T) {
+	// arrange
+	testdata := `{"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}, "distance": 12.5}`
+	// action
+	var result nearbyVehicleState
+	err := json.Unmarshal([]byte(testdata), &result)
+	// verify
+	verify.Ok(t, err)
+	verify.Equals(t, int64(1), result.VehicleID)
+	verify.Equals(t, orb.Point{20, 30}, result.Position.Geometry())
+	verify.Equals(t, 12.5, result.Distance)
+}
+
+func TestJsonToGeofenceWithWKT(t *testing.T) {
+	// arrange
+	testdata := `{"name": "depot", "area": "POLYGON((19 29, 21 29, 21 31, 19 29))"}`
+	// action
+	var result geofence
+	err := json.Unmarshal([]byte(testdata), &result)
+	// verify
+	verify.Ok(t, err)
+	verify.Equals(t, "depot", result.Name)
+	verify.Equals(t, orb.Polygon{{{19, 29}, {21, 29}, {21, 31}, {19, 29}}}, result.Area.Geometry())
+}
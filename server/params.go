@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// parseBBox parses a bounding box given as "minLon,minLat,maxLon,maxLat".
+// A box whose minLon is greater than its maxLon crosses the antimeridian.
+func parseBBox(value string) (orb.Bound, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return orb.Bound{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		c, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return orb.Bound{}, fmt.Errorf("invalid bbox coordinate %q", part)
+		}
+		coords[i] = c
+	}
+	return newBBox(coords)
+}
+
+// newBBox creates a bounding box from minLon, minLat, maxLon and maxLat, checking their range, see parseBBox.
+func newBBox(coords [4]float64) (orb.Bound, error) {
+	bound := orb.Bound{
+		Min: orb.Point{coords[0], coords[1]},
+		Max: orb.Point{coords[2], coords[3]},
+	}
+	for _, lon := range []float64{bound.Min.Lon(), bound.Max.Lon()} {
+		if lon < -180 || lon > 180 {
+			return orb.Bound{}, fmt.Errorf("bbox longitude %v out of range", lon)
+		}
+	}
+	for _, lat := range []float64{bound.Min.Lat(), bound.Max.Lat()} {
+		if lat < -90 || lat > 90 {
+			return orb.Bound{}, fmt.Errorf("bbox latitude %v out of range", lat)
+		}
+	}
+	if bound.Min.Lat() > bound.Max.Lat() {
+		return orb.Bound{}, fmt.Errorf("bbox minLat must not be greater than maxLat")
+	}
+	return bound, nil
+}
+
+// splitAtAntimeridian returns the given bounding box as one or two boxes
+// that do not cross the antimeridian.
+func splitAtAntimeridian(bound orb.Bound) []orb.Bound {
+	if bound.Min.Lon() <= bound.Max.Lon() {
+		return []orb.Bound{bound}
+	}
+	return []orb.Bound{
+		{Min: bound.Min, Max: orb.Point{180, bound.Max.Lat()}},
+		{Min: orb.Point{-180, bound.Min.Lat()}, Max: bound.Max},
+	}
+}
+
+// checkLonLat returns an error unless p is a finite position in lon/lat range.
+// NaN fails every comparison and is rejected explicitly.
+func checkLonLat(p orb.Point) error {
+	for _, v := range p {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("position must have finite coordinates")
+		}
+	}
+	if p.Lon() < -180 || p.Lon() > 180 || p.Lat() < -90 || p.Lat() > 90 {
+		return fmt.Errorf("position %v out of range", p)
+	}
+	return nil
+}
+
+// parseLonLat parses a position given as separate longitude and latitude values.
+func parseLonLat(lon, lat string) (orb.Point, error) {
+	x, err := strconv.ParseFloat(lon, 64)
+	if err != nil || x < -180 || x > 180 {
+		return orb.Point{}, fmt.Errorf("lon must be a number between -180 and 180")
+	}
+	y, err := strconv.ParseFloat(lat, 64)
+	if err != nil || y < -90 || y > 90 {
+		return orb.Point{}, fmt.Errorf("lat must be a number between -90 and 90")
+	}
+	return orb.Point{x, y}, nil
+}
+
+// parseIDList parses a comma separated list of ids.
+func parseIDList(value string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Time buckets results can be grouped into.
+const (
+	timeBucketHour = "hour"
+	timeBucketDay  = "day"
+)
+
+// parseTimeRange parses optional RFC 3339 from/to values.
+// Empty values are returned as nil.
+func parseTimeRange(from, to string) (*time.Time, *time.Time, error) {
+	var fromTime, toTime *time.Time
+	if from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, nil, fmt.Errorf("from must be a RFC 3339 timestamp")
+		}
+		fromTime = &t
+	}
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, nil, fmt.Errorf("to must be a RFC 3339 timestamp")
+		}
+		toTime = &t
+	}
+	if fromTime != nil && toTime != nil && !fromTime.Before(*toTime) {
+		return nil, nil, fmt.Errorf("from must be before to")
+	}
+	return fromTime, toTime, nil
+}
+
+// parseOrder parses a sort order given as "asc" or "desc" and reports
+// whether it is descending. An empty value is ascending.
+func parseOrder(value string) (bool, error) {
+	switch value {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, fmt.Errorf("order must be asc or desc")
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+)
+
+func TestParseBBox(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		// action
+		result, err := parseBBox("10.5, 50,11,51.5")
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, orb.Bound{Min: orb.Point{10.5, 50}, Max: orb.Point{11, 51.5}}, result)
+	})
+
+	t.Run("crossing antimeridian", func(t *testing.T) {
+		// action
+		result, err := parseBBox("170,-20,-170,-10")
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, orb.Bound{Min: orb.Point{170, -20}, Max: orb.Point{-170, -10}}, result)
+	})
+
+	for _, value := range []string{"", "1,2,3", "a,2,3,4", "-181,0,0,1", "0,-91,1,1", "0,10,1,5"} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			// action
+			_, err := parseBBox(value)
+			// verify
+			verify.Assert(t, err != nil, "expected error for %q", value)
+		})
+	}
+}
+
+func TestSplitAtAntimeridian(t *testing.T) {
+	t.Run("not crossing", func(t *testing.T) {
+		// arrange
+		bound := orb.Bound{Min: orb.Point{10, 50}, Max: orb.Point{11, 51}}
+		// action
+		result := splitAtAntimeridian(bound)
+		// verify
+		verify.Equals(t, []orb.Bound{bound}, result)
+	})
+
+	t.Run("crossing", func(t *testing.T) {
+		// action
+		result := splitAtAntimeridian(orb.Bound{Min: orb.Point{170, -20}, Max: orb.Point{-170, -10}})
+		// verify
+		verify.Equals(t, []orb.Bound{
+			{Min: orb.Point{170, -20}, Max: orb.Point{180, -10}},
+			{Min: orb.Point{-180, -20}, Max: orb.Point{-170, -10}},
+		}, result)
+	})
+}
+
+func TestParseLonLat(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		// action
+		result, err := parseLonLat("13.4", "52.5")
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, orb.Point{13.4, 52.5}, result)
+	})
+
+	for _, value := range [][2]string{{"", "52.5"}, {"13.4", "x"}, {"181", "0"}, {"0", "-91"}} {
+		t.Run("invalid "+value[0]+" "+value[1], func(t *testing.T) {
+			// action
+			_, err := parseLonLat(value[0], value[1])
+			// verify
+			verify.Assert(t, err != nil, "expected error for %v", value)
+		})
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	t.Run("open", func(t *testing.T) {
+		// action
+		from, to, err := parseTimeRange("", "")
+		// verify
+		verify.Ok(t, err)
+		verify.Assert(t, from == nil && to == nil, "expected open range")
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		// action
+		from, to, err := parseTimeRange("2021-06-15T09:00:00Z", "2021-06-15T12:00:00+02:00")
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC), from.UTC())
+		verify.Equals(t, time.Date(2021, 6, 15, 10, 0, 0, 0, time.UTC), to.UTC())
+	})
+
+	for _, value := range [][2]string{{"yesterday", ""}, {"", "2021-06-15"}, {"2021-06-15T10:00:00Z", "2021-06-15T09:00:00Z"}} {
+		t.Run("invalid "+value[0]+" "+value[1], func(t *testing.T) {
+			// action
+			_, _, err := parseTimeRange(value[0], value[1])
+			// verify
+			verify.Assert(t, err != nil, "expected error for %v", value)
+		})
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	descending, err := parseOrder("")
+	verify.Ok(t, err)
+	verify.Equals(t, false, descending)
+
+	descending, err = parseOrder("desc")
+	verify.Ok(t, err)
+	verify.Equals(t, true, descending)
+
+	_, err = parseOrder("random")
+	verify.Assert(t, err != nil, "expected error")
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+)
+
+const mimeGeoJSON = "application/geo+json"
+
+// renderGeoJSON writes obj as GeoJSON (RFC 7946) with the matching content type.
+func renderGeoJSON(c *gin.Context, code int, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(code, mimeGeoJSON, data)
+}
+
+// renderKML writes features as KML document, styled by the query parameters, see kmlStyleFromQuery.
+func (srv ApplicationServer) renderKML(c *gin.Context, name string, features []*geojson.Feature) {
+	style, err := kmlStyleFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	doc, err := newKML(name, style, features)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", mimeKML)
+	err = writeKML(c.Writer, doc)
+	if err != nil {
+		srv.logger.Printf("Aborted KML export: %v\n", err)
+	}
+}
+
+// formats maps the values of the format query parameter to content types.
+var formats = map[string]string{
+	"json":    gin.MIMEJSON,
+	"geojson": mimeGeoJSON,
+	"csv":     mimeCSV,
+	"kml":     mimeKML,
+}
+
+// negotiateFormat returns the content type requested by the format query parameter or,
+// if not given, the best match of the Accept header. Without match the first offer is returned.
+// Requesting a format that is not offered by the format parameter is an error.
+func negotiateFormat(c *gin.Context, offered ...string) (string, error) {
+	if format, ok := c.GetQuery("format"); ok {
+		for _, mime := range offered {
+			if formats[format] == mime {
+				return mime, nil
+			}
+		}
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+	if mime := c.NegotiateFormat(offered...); mime != "" {
+		return mime, nil
+	}
+	return offered[0], nil
+}
+
+// renderCSV streams a CSV document, starting with header. The rows are passed to write
+// by each while they are read. If each fails before any data was sent, an error response
+// is written instead, otherwise the response is cut short and the error is logged.
+// Instead of the server wide write timeout, each row may take streamWriteTimeout.
+func (srv ApplicationServer) renderCSV(c *gin.Context, opts csvOptions, header []string, each func(write func([]string) error) error) {
+	startStream(c)
+	c.Header("Content-Type", mimeCSV+"; charset=utf-8")
+	w := csv.NewWriter(c.Writer)
+	w.Comma = opts.Delimiter
+	write := func(record []string) error {
+		extendWriteDeadline(c)
+		return w.Write(record)
+	}
+	err := write(header)
+	if err == nil {
+		err = each(write)
+	}
+	if err == nil {
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil && !c.Writer.Written() {
+		c.Header("Content-Type", "")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		srv.logger.Printf("Aborted CSV export: %v\n", err)
+	}
+}
+
+// etagMatches reports whether the If-None-Match header lists etag, comparing weakly.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	var tests = []struct {
+		query    string
+		accept   string
+		expected string
+		valid    bool
+	}{
+		{"", "", gin.MIMEJSON, true},
+		{"", "*/*", gin.MIMEJSON, true},
+		{"", "text/html", gin.MIMEJSON, true},
+		{"", "text/csv", mimeCSV, true},
+		{"", "application/geo+json", mimeGeoJSON, true},
+		{"?format=csv", "application/json", mimeCSV, true},
+		{"?format=geojson", "", mimeGeoJSON, true},
+		{"?format=kml", "", "", false},
+		{"?format=xml", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.query+" "+test.accept, func(t *testing.T) {
+			// arrange
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/"+test.query, nil)
+			c.Request.Header.Set("Accept", test.accept)
+			// action
+			result, err := negotiateFormat(c, gin.MIMEJSON, mimeGeoJSON, mimeCSV)
+			// verify
+			if !test.valid {
+				verify.Assert(t, err != nil, "expected error")
+				return
+			}
+			verify.Ok(t, err)
+			verify.Equals(t, test.expected, result)
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	verify.Assert(t, etagMatches(`"a"`, `"a"`), "same etag")
+	verify.Assert(t, etagMatches(`"b", W/"a"`, `"a"`), "weak etag in list")
+	verify.Assert(t, etagMatches(`*`, `"a"`), "any etag")
+	verify.Assert(t, !etagMatches(``, `"a"`), "no etag")
+	verify.Assert(t, !etagMatches(`"b"`, `"a"`), "other etag")
+}
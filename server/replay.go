@@ -0,0 +1,212 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Bounds of the replay speed. Replayed times and the gaps between states are scaled by the speed,
+// which has to stay far from the limits of time.Duration.
+const (
+	replayMinSpeed = 0.01
+	replayMaxSpeed = 1000
+)
+
+// checkReplaySpeed returns an error unless speed is within replayMinSpeed and replayMaxSpeed.
+func checkReplaySpeed(speed float64) error {
+	if !(speed >= replayMinSpeed && speed <= replayMaxSpeed) {
+		return fmt.Errorf("speed must be a number between %v and %v", replayMinSpeed, replayMaxSpeed)
+	}
+	return nil
+}
+
+// replayStatus is the playback state of a replay session as reported to clients.
+type replayStatus struct {
+	ID       string    `json:"id"`
+	Position time.Time `json:"position"`
+	Speed    float64   `json:"speed"`
+	Paused   bool      `json:"paused"`
+}
+
+// replayControl changes the playback of a replay session. Missing fields are left unchanged.
+type replayControl struct {
+	Paused *bool `json:"paused"`
+	// Position seeks to the given time of the replayed history.
+	Position *time.Time `json:"position"`
+	Speed    *float64   `json:"speed"`
+}
+
+// validate returns an error if the control cannot be applied.
+func (ctl replayControl) validate() error {
+	if ctl.Speed != nil {
+		return checkReplaySpeed(*ctl.Speed)
+	}
+	return nil
+}
+
+// replayClock is the playback clock of a replay session. Unless paused, the replayed time
+// advances Speed times as fast as the wall clock, starting from Position at WallTime.
+// It is stored with the session, so that any instance can control it, see updateReplaySession.
+type replayClock struct {
+	Position time.Time
+	WallTime time.Time
+	Speed    float64
+	Paused   bool
+	// Seeks counts the jumps of Position, so that the stream can find its place again.
+	Seeks int
+}
+
+// positionAt returns the replayed time at the given wall clock time.
+func (clock replayClock) positionAt(now time.Time) time.Time {
+	if clock.Paused {
+		return clock.Position
+	}
+	return clock.Position.Add(time.Duration(float64(now.Sub(clock.WallTime)) * clock.Speed))
+}
+
+// control returns the clock with the changes applied at the given wall clock time.
+// The control has to be validated before.
+func (clock replayClock) control(ctl replayControl, now time.Time) replayClock {
+	clock.Position, clock.WallTime = clock.positionAt(now), now
+	if ctl.Speed != nil {
+		clock.Speed = *ctl.Speed
+	}
+	if ctl.Paused != nil {
+		clock.Paused = *ctl.Paused
+	}
+	if ctl.Position != nil {
+		clock.Position = *ctl.Position
+		clock.Seeks++
+	}
+	return clock
+}
+
+// status returns the playback state at the given wall clock time.
+func (clock replayClock) status(id string, now time.Time) replayStatus {
+	return replayStatus{ID: id, Position: clock.positionAt(now), Speed: clock.Speed, Paused: clock.Paused}
+}
+
+// replaySession is a replay stream served by this instance.
+type replaySession struct {
+	id string
+
+	mu    sync.Mutex
+	clock replayClock
+
+	// changed is signalled when the clock is replaced, done is closed when the session is closed.
+	changed chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+func newReplaySession(id string, start time.Time, speed float64, now time.Time) *replaySession {
+	return &replaySession{
+		id:      id,
+		clock:   replayClock{Position: start, WallTime: now, Speed: speed},
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// playhead returns the replayed time at now along with the playback settings and the number of seeks.
+func (s *replaySession) playhead(now time.Time) (time.Time, float64, bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.positionAt(now), s.clock.Speed, s.clock.Paused, s.clock.Seeks
+}
+
+func (s *replaySession) status(now time.Time) replayStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.status(s.id, now)
+}
+
+// setClock replaces the clock, e.g. after it was changed by another instance, and wakes up the stream.
+func (s *replaySession) setClock(clock replayClock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *replaySession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// replayRegistry holds the sessions of the replay streams served by this instance,
+// so that changes of their clocks can be applied and they are ended on shutdown.
+type replayRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*replaySession
+	closed   bool
+}
+
+func newReplayRegistry() *replayRegistry {
+	return &replayRegistry{sessions: map[string]*replaySession{}}
+}
+
+// start registers a new session with a random id. If the registry is closed, the session is closed immediately.
+func (r *replayRegistry) start(start time.Time, speed float64) (*replaySession, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	session := newReplaySession(hex.EncodeToString(id), start, speed, time.Now())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		session.close()
+	} else {
+		r.sessions[session.id] = session
+	}
+	return session, nil
+}
+
+// get returns the session with the given id, if its stream is served by this instance.
+func (r *replayRegistry) get(id string) (*replaySession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	return session, ok
+}
+
+// ids returns the ids of all sessions.
+func (r *replayRegistry) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.sessions))
+	for id := range r.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// remove removes and closes the session.
+func (r *replayRegistry) remove(session *replaySession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, session.id)
+	session.close()
+}
+
+// close closes all sessions and rejects new ones, e.g. on shutdown.
+func (r *replayRegistry) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for id, session := range r.sessions {
+		delete(r.sessions, id)
+		session.close()
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+)
+
+func TestReplayClock(t *testing.T) {
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	paused, resumed, speed := true, false, 2.0
+
+	t.Run("advances by speed", func(t *testing.T) {
+		// arrange
+		unit := replayClock{Position: start, WallTime: now, Speed: 10}
+		// action
+		position := unit.positionAt(now.Add(time.Minute))
+		// verify
+		verify.Equals(t, start.Add(10*time.Minute), position)
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		// arrange
+		unit := replayClock{Position: start, WallTime: now, Speed: 10}
+		// action
+		unit = unit.control(replayControl{Paused: &paused}, now.Add(time.Minute))
+		pausedAt := unit.positionAt(now.Add(time.Hour))
+		unit = unit.control(replayControl{Paused: &resumed, Speed: &speed}, now.Add(time.Hour))
+		position := unit.positionAt(now.Add(time.Hour + time.Minute))
+		// verify
+		verify.Equals(t, start.Add(10*time.Minute), pausedAt)
+		verify.Equals(t, start.Add(12*time.Minute), position)
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		// arrange
+		unit := replayClock{Position: start, WallTime: now, Speed: 10}
+		target := start.Add(-time.Hour)
+		// action
+		unit = unit.control(replayControl{Position: &target}, now.Add(time.Minute))
+		// verify
+		verify.Equals(t, target, unit.positionAt(now.Add(time.Minute)))
+		verify.Equals(t, 1, unit.Seeks)
+	})
+
+	t.Run("invalid speed", func(t *testing.T) {
+		for _, invalid := range []float64{0, -1, replayMinSpeed / 2, replayMaxSpeed * 2, math.NaN()} {
+			// arrange
+			invalid := invalid
+			// action
+			err := replayControl{Speed: &invalid}.validate()
+			// verify
+			verify.Assert(t, err != nil, "speed %v accepted", invalid)
+		}
+	})
+}
+
+func TestReplaySession(t *testing.T) {
+	t.Run("set clock", func(t *testing.T) {
+		// arrange
+		start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+		now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+		unit := newReplaySession("a", start, 10, now)
+		// action
+		unit.setClock(replayClock{Position: start, WallTime: now, Speed: 2, Seeks: 1})
+		// verify
+		verify.Equals(t, replayStatus{ID: "a", Position: start.Add(2 * time.Minute), Speed: 2}, unit.status(now.Add(time.Minute)))
+		verify.Equals(t, 1, len(unit.changed))
+	})
+}
+
+func TestReplayRegistry(t *testing.T) {
+	t.Run("start and remove", func(t *testing.T) {
+		// arrange
+		unit := newReplayRegistry()
+		// action
+		session, err := unit.start(time.Now(), 1)
+		verify.Ok(t, err)
+		_, ok := unit.get(session.id)
+		unit.remove(session)
+		_, removed := unit.get(session.id)
+		// verify
+		verify.Equals(t, 32, len(session.id))
+		verify.Assert(t, ok, "session not registered")
+		verify.Assert(t, !removed, "session still registered")
+		<-session.done
+	})
+
+	t.Run("close", func(t *testing.T) {
+		// arrange
+		unit := newReplayRegistry()
+		session, err := unit.start(time.Now(), 1)
+		verify.Ok(t, err)
+		// action
+		unit.close()
+		late, err := unit.start(time.Now(), 1)
+		verify.Ok(t, err)
+		// verify
+		<-session.done
+		<-late.done
+		unit.remove(session) // must not close twice
+	})
+}
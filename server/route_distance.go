@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDistanceRange is the longest time range the distance of all vehicles can be requested for.
+const maxDistanceRange = 31 * 24 * time.Hour
+
+// distanceQuery holds the parameters of distance requests.
+type distanceQuery struct {
+	filter   vehicleStateFilter
+	config   DistanceConfig
+	bucket   string
+	location *time.Location
+}
+
+// distanceQueryFromRequest reads the optional query parameters from/to, bucket (hour or day),
+// tz (IANA time zone the buckets start in, default UTC) and minDistance and maxAccuracy,
+// overriding the configured jitter filter.
+func (srv ApplicationServer) distanceQueryFromRequest(c *gin.Context) (distanceQuery, error) {
+	query := distanceQuery{config: srv.distanceConfig, bucket: c.Query("bucket"), location: time.UTC}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		return query, err
+	}
+	query.filter.From, query.filter.To = from, to
+	if tz := c.Query("tz"); tz != "" {
+		query.location, err = time.LoadLocation(tz)
+		if err != nil {
+			return query, err
+		}
+	}
+	for name, value := range map[string]*float64{
+		"minDistance": &query.config.MinDistance,
+		"maxAccuracy": &query.config.MaxAccuracy,
+	} {
+		if param := c.Query(name); param != "" {
+			v, err := strconv.ParseFloat(param, 64)
+			if err != nil || v < 0 {
+				return query, fmt.Errorf("%s must be a non-negative number", name)
+			}
+			*value = v
+		}
+	}
+	return query, nil
+}
+
+// getDistanceOfVehicle returns the distance a single vehicle travelled, optionally grouped by hour or day.
+func (srv ApplicationServer) getDistanceOfVehicle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, err := srv.distanceQueryFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	counter, err := newDistanceCounter(id, query.config, query.bucket, query.location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	query.filter.VehicleID = id
+	err = forEachVehicleState(srv.logger, srv.db, query.filter, func(state vehicleState) error {
+		counter.add(state)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, counter.result)
+}
+
+// getDistances returns the distance travelled by each vehicle, optionally grouped by hour or day.
+// Vehicles without states in the time range are omitted. The time range from/to is required
+// and must not exceed maxDistanceRange, as the states of all vehicles in it are read.
+func (srv ApplicationServer) getDistances(c *gin.Context) {
+	query, err := srv.distanceQueryFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.filter.From == nil || query.filter.To == nil || query.filter.To.Sub(*query.filter.From) > maxDistanceRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("from and to are required and must not be more than %v apart", maxDistanceRange)})
+		return
+	}
+	counters, err := newDistanceCounters(query.config, query.bucket, query.location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = forEachVehicleState(srv.logger, srv.db, query.filter, counters.add)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Distances []vehicleDistance `json:"distances"`
+	}{
+		Distances: counters.results(),
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// validateGeofenceArea checks that area is a Polygon or MultiPolygon made of
+// closed rings with at least four finite positions in lon/lat range.
+func validateGeofenceArea(area orb.Geometry) error {
+	var polygons []orb.Polygon
+	switch a := area.(type) {
+	case orb.Polygon:
+		polygons = []orb.Polygon{a}
+	case orb.MultiPolygon:
+		polygons = a
+	default:
+		return errors.New("area must be a Polygon or MultiPolygon")
+	}
+	if len(polygons) == 0 {
+		return errors.New("area must not be empty")
+	}
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			return errors.New("polygon must have an exterior ring")
+		}
+		for _, ring := range polygon {
+			if len(ring) < 4 {
+				return errors.New("ring must have at least four positions")
+			}
+			if !ring.Closed() {
+				return errors.New("ring must be closed")
+			}
+			for _, p := range ring {
+				if err := checkLonLat(p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bindGeofence reads and validates a geofence from the request body.
+func bindGeofence(c *gin.Context) (geofence, error) {
+	var fence geofence
+	if err := c.ShouldBindJSON(&fence); err != nil {
+		return fence, err
+	}
+	return fence, validateGeofenceArea(fence.Area.Geometry())
+}
+
+func (srv ApplicationServer) addGeofence(c *gin.Context) {
+	fence, err := bindGeofence(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := addGeofence(srv.logger, srv.db, fence)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		GeofenceId int64 `json:"geofenceId"`
+	}{
+		GeofenceId: id,
+	}
+	c.JSON(http.StatusCreated, res)
+}
+
+func (srv ApplicationServer) updateGeofence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fence, err := bindGeofence(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = updateGeofence(srv.logger, srv.db, id, fence)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (srv ApplicationServer) deleteGeofence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = deleteGeofence(srv.logger, srv.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getGeofence returns a single geofence, as GeoJSON feature or KML placemark if requested.
+func (srv ApplicationServer) getGeofence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := negotiateFormat(c, gin.MIMEJSON, mimeGeoJSON, mimeKML)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fence, err := getGeofence(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	switch format {
+	case mimeGeoJSON:
+		renderGeoJSON(c, http.StatusOK, newGeofenceFeature(fence))
+		return
+	case mimeKML:
+		srv.renderKML(c, fence.Name, []*geojson.Feature{newGeofenceFeature(fence)})
+		return
+	}
+	out, err := encodeGeofence(fence, geometryFormat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Geofence interface{} `json:"geofence"`
+	}{
+		Geofence: out,
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// getGeofences returns all geofences, as GeoJSON feature collection or KML document if requested.
+func (srv ApplicationServer) getGeofences(c *gin.Context) {
+	format, err := negotiateFormat(c, gin.MIMEJSON, mimeGeoJSON, mimeKML)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fences, err := getGeofences(srv.logger, srv.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	switch format {
+	case mimeGeoJSON:
+		renderGeoJSON(c, http.StatusOK, newGeofenceFeatureCollection(fences))
+		return
+	case mimeKML:
+		srv.renderKML(c, "geofences", newGeofenceFeatureCollection(fences).Features)
+		return
+	}
+	out, err := encodeGeofences(fences, geometryFormat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Geofences interface{} `json:"geofences"`
+	}{
+		Geofences: out,
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// getGeofencesContaining returns all geofences that contain lon/lat.
+func (srv ApplicationServer) getGeofencesContaining(c *gin.Context) {
+	position, err := parseLonLat(c.Query("lon"), c.Query("lat"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fences, err := getGeofencesContaining(srv.logger, srv.db, position)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out, err := encodeGeofences(fences, geometryFormat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Geofences interface{} `json:"geofences"`
+	}{
+		Geofences: out,
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getGeofenceEventsOfGeofence returns the enter and exit events of a single geofence.
+func (srv ApplicationServer) getGeofenceEventsOfGeofence(c *gin.Context) {
+	srv.getGeofenceEvents(c, func(id int64) (geofenceEventFilter, error) {
+		_, err := getGeofence(srv.logger, srv.db, id)
+		return geofenceEventFilter{GeofenceID: id}, err
+	})
+}
+
+// getGeofenceEventsOfVehicle returns the enter and exit events of a single vehicle.
+func (srv ApplicationServer) getGeofenceEventsOfVehicle(c *gin.Context) {
+	srv.getGeofenceEvents(c, func(id int64) (geofenceEventFilter, error) {
+		_, err := getVehicle(srv.logger, srv.db, id)
+		return geofenceEventFilter{VehicleID: id}, err
+	})
+}
+
+// getGeofenceEvents returns the events of the resource identified by the id parameter,
+// optionally restricted to from/to. filterOf checks that the resource exists
+// and returns the matching filter.
+func (srv ApplicationServer) getGeofenceEvents(c *gin.Context, filterOf func(id int64) (geofenceEventFilter, error)) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := filterOf(id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filter.From, filter.To = from, to
+	events, err := getGeofenceEvents(srv.logger, srv.db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Events []geofenceEvent `json:"events"`
+	}{
+		Events: events,
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGeofenceEventsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
+	depot := orb.Polygon{{{19, 29}, {21, 29}, {21, 31}, {19, 31}, {19, 29}}}
+	geofenceID, _ := addGeofence(unit.logger, unit.db, geofence{Name: "depot", Area: *geojson.NewGeometry(depot)})
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: start})
+	addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{25, 30}), Timestamp: start.Add(time.Hour)})
+
+	for _, path := range []string{
+		fmt.Sprintf("/vehicles/%d/events", vehicleID),
+		fmt.Sprintf("/geofences/%d/events", geofenceID),
+	} {
+		t.Run("Getting events from "+path, func(t *testing.T) {
+			// arrange
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", path+"?from=2021-06-15T00:00:00Z", nil)
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusOK, res.Code)
+			result := struct {
+				Events []geofenceEvent `json:"events"`
+			}{}
+			err := json.NewDecoder(res.Body).Decode(&result)
+			verify.Ok(t, err)
+			verify.Equals(t, 2, len(result.Events))
+			verify.Equals(t, 3600.0, *result.Events[1].Dwell)
+		})
+	}
+
+	t.Run("Getting events of unknown geofence should return 404", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/geofences/%d/events", geofenceID+1), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotFound, res.Code)
+	})
+}
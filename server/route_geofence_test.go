@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestValidateGeofenceArea(t *testing.T) {
+	// arrange
+	valid := orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}
+	tests := []struct {
+		name  string
+		area  orb.Geometry
+		valid bool
+	}{
+		{"polygon", valid, true},
+		{"multipolygon", orb.MultiPolygon{valid, valid}, true},
+		{"point", orb.Point{0, 0}, false},
+		{"missing", nil, false},
+		{"empty multipolygon", orb.MultiPolygon{}, false},
+		{"open ring", orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}, false},
+		{"short ring", orb.Polygon{{{0, 0}, {1, 0}, {0, 0}}}, false},
+		{"out of range", orb.Polygon{{{0, 0}, {181, 0}, {1, 1}, {0, 0}}}, false},
+		{"not a number", orb.Polygon{{{0, 0}, {math.NaN(), 0}, {1, 1}, {0, 0}}}, false},
+		{"infinite", orb.Polygon{{{0, 0}, {1, math.Inf(1)}, {1, 1}, {0, 0}}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// action
+			err := validateGeofenceArea(test.area)
+			// verify
+			verify.Equals(t, test.valid, err == nil)
+		})
+	}
+}
+
+func TestCrudGeofenceIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	createTableGeofence(unit.logger, unit.db)
+
+	var id int64
+	t.Run("Adding geofence", func(t *testing.T) {
+		// arrange
+		testdata := `
+		{
+			"name": "depot",
+			"category": "depot",
+			"area": {
+				"type": "Polygon",
+				"coordinates": [[[19, 29], [21, 29], [21, 31], [19, 31], [19, 29]]]
+			}
+		}
+		`
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/geofences", strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusCreated, res.Code)
+		result := struct {
+			GeofenceId int64 `json:"geofenceId"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		id = result.GeofenceId
+	})
+
+	t.Run("Adding geofence with point area should return 400", func(t *testing.T) {
+		// arrange
+		testdata := `{"name": "depot", "area": {"type": "Point", "coordinates": [20, 30]}}`
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/geofences", strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Getting geofence by id", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/geofences/%d", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			Geofence geofence `json:"geofence"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, "depot", result.Geofence.Name)
+		_, isPolygon := result.Geofence.Area.Geometry().(orb.Polygon)
+		verify.Assert(t, isPolygon, "area is not a polygon")
+	})
+
+	t.Run("Getting all geofences as GeoJSON", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/geofences?format=geojson", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result, err := geojson.UnmarshalFeatureCollection(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.Features))
+		verify.Equals(t, "depot", result.Features[0].Properties["name"])
+	})
+
+	t.Run("Getting all geofences as KML", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/geofences?fillColor=00ff0040", nil)
+		req.Header.Set("Accept", "application/vnd.google-earth.kml+xml")
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/vnd.google-earth.kml+xml", res.Header().Get("Content-Type"))
+		verify.Assert(t, strings.Contains(res.Body.String(), "<name>depot</name>"), "placemark missing")
+		verify.Assert(t, strings.Contains(res.Body.String(), "<color>4000ff00</color>"), "style missing")
+	})
+
+	t.Run("Getting geofences containing position", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/geofences/contains?lon=20&lat=30", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			Geofences []geofence `json:"geofences"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.Geofences))
+		verify.Equals(t, id, result.Geofences[0].ID)
+	})
+
+	t.Run("Updating geofence", func(t *testing.T) {
+		// arrange
+		testdata := `{"name": "site", "area": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}`
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/geofences/%d", id), strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNoContent, res.Code)
+	})
+
+	t.Run("Deleting geofence by id", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/geofences/%d", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNoContent, res.Code)
+	})
+
+	t.Run("Getting geofence by id should return 404", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/geofences/%d", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotFound, res.Code)
+	})
+}
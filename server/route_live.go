@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// liveWriteWait is the time allowed to write a message to the client.
+	liveWriteWait = 10 * time.Second
+	// livePongWait is the time allowed to read the next pong from the client.
+	livePongWait = 60 * time.Second
+	// livePingPeriod is the interval of pings, shorter than livePongWait.
+	livePingPeriod = livePongWait * 9 / 10
+	// liveMaxRequestSize is the maximum size in bytes of a request sent by the client.
+	liveMaxRequestSize = 64 << 10
+)
+
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// live upgrades the request to a WebSocket connection. The client sends subscribe and unsubscribe
+// requests, see liveRequest, and receives the vehicle states and geofence events matching its
+// subscriptions, see liveConnection.
+func (srv ApplicationServer) live(c *gin.Context) {
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	conn, err := liveUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// upgrader has replied with an error already
+		return
+	}
+	defer conn.Close()
+
+	l := newLiveConnection()
+	srv.broker.subscribe(l)
+	defer srv.broker.unsubscribe(l)
+	go srv.writeLive(conn, l, geometryFormat)
+
+	conn.SetReadLimit(liveMaxRequestSize)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req liveRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			l.reject(err)
+			continue
+		}
+		l.handle(req)
+	}
+}
+
+// writeLive writes the queued messages of l and pings the client, until l or conn are closed.
+func (srv ApplicationServer) writeLive(conn *websocket.Conn, l *liveConnection, geometryFormat string) {
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+	// closing the connection ends the reading handler as well
+	defer conn.Close()
+	for {
+		select {
+		case <-l.done:
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(liveWriteWait),
+			)
+			return
+		case <-l.wake:
+			for _, msg := range l.take() {
+				if msg.state != nil {
+					data, err := encodeVehicleState(*msg.state, geometryFormat)
+					if err != nil {
+						srv.logger.Printf("Skipped vehicle state %d: %v\n", msg.state.ID, err)
+						continue
+					}
+					msg.VehicleState = data
+				}
+				conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+				if err := conn.WriteJSON(msg); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/paulmach/orb"
+)
+
+// liveTestMessage is a message received from a live connection.
+type liveTestMessage struct {
+	Type          string   `json:"type"`
+	ID            string   `json:"id"`
+	Subscriptions []string `json:"subscriptions"`
+	VehicleState  struct {
+		ID       int64  `json:"id"`
+		Position string `json:"position"`
+	} `json:"vehicleState"`
+	GeofenceEvent geofenceEvent `json:"geofenceEvent"`
+	Error         string        `json:"error"`
+}
+
+func TestLive(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	server := httptest.NewServer(unit.router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/live?geometryFormat=wkt"
+
+	t.Run("Receiving subscribed updates", func(t *testing.T) {
+		// arrange
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		verify.Ok(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var reply liveTestMessage
+		verify.Ok(t, conn.WriteJSON(liveRequest{Type: liveSubscribe, ID: "trucks", VehicleIDs: []int64{2}}))
+		verify.Ok(t, conn.ReadJSON(&reply))
+		verify.Equals(t, liveSubscribed, reply.Type)
+		verify.Equals(t, "trucks", reply.ID)
+		// action
+		unit.broker.publish(
+			[]vehicleState{brokerTestState(1, 1, orb.Point{20, 30}), brokerTestState(2, 2, orb.Point{20, 30})},
+			[]geofenceEvent{{ID: 5, VehicleID: 2, VehicleStateID: 2, Type: geofenceEventEnter}},
+		)
+		var event, state liveTestMessage
+		verify.Ok(t, conn.ReadJSON(&event))
+		verify.Ok(t, conn.ReadJSON(&state))
+		// verify
+		verify.Equals(t, liveGeofenceEvent, event.Type)
+		verify.Equals(t, int64(5), event.GeofenceEvent.ID)
+		verify.Equals(t, []string{"trucks"}, event.Subscriptions)
+		verify.Equals(t, liveVehicleState, state.Type)
+		verify.Equals(t, int64(2), state.VehicleState.ID)
+		verify.Equals(t, "POINT(20 30)", state.VehicleState.Position)
+	})
+
+	t.Run("Invalid request should return error", func(t *testing.T) {
+		// arrange
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		verify.Ok(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// action
+		verify.Ok(t, conn.WriteMessage(websocket.TextMessage, []byte("subscribe")))
+		var reply liveTestMessage
+		verify.Ok(t, conn.ReadJSON(&reply))
+		// verify
+		verify.Equals(t, liveError, reply.Type)
+		verify.Assert(t, reply.Error != "", "error is missing")
+	})
+
+	t.Run("Invalid parameters should return 400", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/live?geometryFormat=svg", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventReplay is the SSE event name of the replay status, sent on start and after each change.
+	eventReplay = "replay"
+	// eventReplayEnd is the SSE event name sent once the last state has been replayed.
+	eventReplayEnd = "end"
+	// headerReplaySession is the response header carrying the id of the replay session.
+	headerReplaySession = "X-Replay-Session"
+	// replayPageSize is the number of states loaded at once while replaying.
+	replayPageSize = 500
+)
+
+// replayVehicleStates streams the stored states of a vehicle between from and to as server-sent events,
+// in the same format as streamVehicleStates. The gaps between the events follow the original timestamps,
+// divided by speed. The stream is controlled by the id of its replay session on any instance, see updateReplay.
+func (srv ApplicationServer) replayVehicleStates(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	speed := 1.0
+	if value := c.Query("speed"); value != "" {
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			speed = 0 // reported as out of range
+		}
+		if err := checkReplaySpeed(speed); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filter := vehicleStateFilter{VehicleID: id, From: from, To: to}
+	page, err := getVehicleStatesPage(srv.logger, srv.db, filter, nil, replayPageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// start with the first state instead of waiting for it
+	start := time.Now()
+	if len(page) > 0 {
+		start = page[0].Timestamp
+	} else if from != nil {
+		start = *from
+	}
+	session, err := srv.replays.start(start, speed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer srv.replays.remove(session)
+	err = addReplaySession(srv.logger, srv.db, session.id, session.clock)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer func() {
+		if err := deleteReplaySession(srv.logger, srv.db, session.id); err != nil {
+			srv.logger.Printf("Could not delete replay %s: %v\n", session.id, err)
+		}
+	}()
+	c.Header(headerReplaySession, session.id)
+	srv.playReplay(c, session, filter, page, geometryFormat)
+}
+
+// playReplay writes the states matching filter, ordered by timestamp, when they are reached by the session.
+// States are loaded in pages of replayPageSize as the playback advances, starting with page.
+// The stream stays open after the end, so that the client can still seek back.
+func (srv ApplicationServer) playReplay(c *gin.Context, session *replaySession, filter vehicleStateFilter, page []vehicleState, geometryFormat string) {
+	startStream(c)
+	c.Header("Content-Type", mimeEventStream)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	writeState := func(state vehicleState) error {
+		data, err := encodeVehicleState(state, geometryFormat)
+		if err != nil {
+			return err
+		}
+		extendWriteDeadline(c)
+		return sse.Encode(c.Writer, sse.Event{
+			Id:    strconv.FormatInt(state.ID, 10),
+			Event: eventVehicleState,
+			Data:  data,
+		})
+	}
+	writeStatus := func(event string) error {
+		extendWriteDeadline(c)
+		return sse.Encode(c.Writer, sse.Event{Event: event, Data: session.status(time.Now())})
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	next, seeks, ended := 0, 0, false
+	exhausted := len(page) < replayPageSize
+	err := writeStatus(eventReplay)
+	for err == nil {
+		position, speed, paused, n := session.playhead(time.Now())
+		if n != seeks {
+			seek := filter
+			if seek.From == nil || position.After(*seek.From) {
+				seek.From = &position
+			}
+			page, err = getVehicleStatesPage(srv.logger, srv.db, seek, nil, replayPageSize)
+			next, exhausted = 0, len(page) < replayPageSize
+			seeks, ended = n, false
+		}
+		for err == nil {
+			if next == len(page) && !exhausted {
+				last := page[len(page)-1]
+				page, err = getVehicleStatesPage(srv.logger, srv.db, filter, &last, replayPageSize)
+				next, exhausted = 0, len(page) < replayPageSize
+				continue
+			}
+			if next == len(page) || page[next].Timestamp.After(position) {
+				break
+			}
+			err = writeState(page[next])
+			next++
+		}
+		if err == nil && next == len(page) && !ended {
+			err = writeStatus(eventReplayEnd)
+			ended = true
+		}
+		if err != nil {
+			break
+		}
+		c.Writer.Flush()
+
+		var due <-chan time.Time
+		var timer *time.Timer
+		if next < len(page) && !paused {
+			timer = time.NewTimer(time.Duration(float64(page[next].Timestamp.Sub(position)) / speed))
+			due = timer.C
+		}
+		closed := false
+		select {
+		case <-c.Request.Context().Done():
+			closed = true
+		case <-session.done:
+			closed = true
+		case <-session.changed:
+			err = writeStatus(eventReplay)
+		case <-due:
+		case <-keepAlive.C:
+			err = refreshReplaySession(srv.logger, srv.db, session.id)
+			if err == nil {
+				extendWriteDeadline(c)
+				_, err = c.Writer.WriteString(": keep-alive\n\n")
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if closed {
+			return
+		}
+	}
+	srv.logger.Printf("Aborted replay %s: %v\n", session.id, err)
+}
+
+// reloadReplay applies the clock stored for a replay session to its stream, if served by this instance.
+func (srv ApplicationServer) reloadReplay(id string) {
+	session, ok := srv.replays.get(id)
+	if !ok {
+		return
+	}
+	clock, err := getReplaySession(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		// stream is ending
+		return
+	}
+	if err != nil {
+		srv.logger.Printf("Could not reload replay %s: %v\n", id, err)
+		return
+	}
+	session.setClock(clock)
+}
+
+func (srv ApplicationServer) getReplay(c *gin.Context) {
+	id := c.Param("id")
+	clock, err := getReplaySession(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Replay replayStatus `json:"replay"`
+	}{
+		Replay: clock.status(id, time.Now()),
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// updateReplay pauses, resumes, seeks or changes the speed of an open replay stream, see replayControl.
+// The stream may be served by another instance, which applies the change once notified.
+func (srv ApplicationServer) updateReplay(c *gin.Context) {
+	id := c.Param("id")
+	var data replayControl
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := data.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	clock, err := updateReplaySession(srv.logger, srv.db, id, data, now)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Replay replayStatus `json:"replay"`
+	}{
+		Replay: clock.status(id, now),
+	}
+	c.JSON(http.StatusOK, res)
+}
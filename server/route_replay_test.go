@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestReplay(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+
+	t.Run("Invalid parameters should return 400", func(t *testing.T) {
+		for _, query := range []string{"speed=0", "speed=a", "speed=100000", "from=2021-06-15", "geometryFormat=svg"} {
+			// arrange
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/vehicles/1/replay?"+query, nil)
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusBadRequest, res.Code)
+		}
+	})
+
+	t.Run("Invalid control should return 400", func(t *testing.T) {
+		for _, body := range []string{`{"speed":1e12}`, `{"speed":0}`, `{"position":"09:00"}`} {
+			// arrange
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", "/replays/abc", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusBadRequest, res.Code)
+		}
+	})
+}
+
+func TestReplayIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go unit.listenForUpdates(ctx)
+	server := httptest.NewServer(unit.router)
+	defer server.Close()
+	// another instance sharing the database
+	other := NewApplicationServer(db, ":5002")
+	otherServer := httptest.NewServer(other.router)
+	defer otherServer.Close()
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, _ := addVehicleState(unit.logger, unit.db, vehicleState{
+			VehicleID: vehicleID,
+			Position:  *geojson.NewGeometry(orb.Point{20, 30 + float64(i)}),
+			Timestamp: time.Date(2021, 6, 15, 9, 0, i, 0, time.UTC),
+		})
+		ids = append(ids, id)
+	}
+	control := func(t *testing.T, id string, body string) (*http.Response, replayStatus) {
+		req, _ := http.NewRequest("PUT", otherServer.URL+"/replays/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		verify.Ok(t, err)
+		defer res.Body.Close()
+		result := struct {
+			Replay replayStatus `json:"replay"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&result)
+		return res, result.Replay
+	}
+
+	t.Run("Reading states in pages", func(t *testing.T) {
+		// action
+		filter := vehicleStateFilter{VehicleID: vehicleID}
+		first, err := getVehicleStatesPage(unit.logger, unit.db, filter, nil, 2)
+		verify.Ok(t, err)
+		second, err := getVehicleStatesPage(unit.logger, unit.db, filter, &first[1], 2)
+		verify.Ok(t, err)
+		// verify
+		verify.Equals(t, 2, len(first))
+		verify.Equals(t, 1, len(second))
+		verify.Equals(t, ids[2], second[0].ID)
+	})
+
+	t.Run("Replaying stored states", func(t *testing.T) {
+		// arrange
+		res, events := openStream(t, server, fmt.Sprintf("/vehicles/%d/replay?from=2021-06-15T09:00:01Z&speed=100", vehicleID), "")
+		defer res.Body.Close()
+		// action
+		var received []map[string]string
+		for len(received) < 4 {
+			event, err := readEvent(events)
+			verify.Ok(t, err)
+			received = append(received, event)
+		}
+		// verify
+		verify.Equals(t, eventReplay, received[0]["event"])
+		verify.Assert(t, strings.Contains(received[1]["data"], "[20,31]"), "unexpected first state %v", received[1])
+		verify.Assert(t, strings.Contains(received[2]["data"], "[20,32]"), "unexpected second state %v", received[2])
+		verify.Equals(t, eventReplayEnd, received[3]["event"])
+	})
+
+	t.Run("Controlling replay on another instance", func(t *testing.T) {
+		// arrange
+		res, events := openStream(t, server, fmt.Sprintf("/vehicles/%d/replay?speed=%v", vehicleID, replayMaxSpeed), "")
+		defer res.Body.Close()
+		id := res.Header.Get(headerReplaySession)
+		for i := 0; i < 5; i++ {
+			_, err := readEvent(events)
+			verify.Ok(t, err)
+		}
+		// action
+		controlled, status := control(t, id, `{"position":"2021-06-15T09:00:01Z", "paused":true}`)
+		replay, err := readEvent(events)
+		verify.Ok(t, err)
+		state, err := readEvent(events)
+		verify.Ok(t, err)
+		// verify
+		verify.Equals(t, http.StatusOK, controlled.StatusCode)
+		expected := replayStatus{ID: id, Position: time.Date(2021, 6, 15, 9, 0, 1, 0, time.UTC), Speed: replayMaxSpeed, Paused: true}
+		verify.Equals(t, expected, status)
+		var result replayStatus
+		verify.Ok(t, json.Unmarshal([]byte(replay["data"]), &result))
+		verify.Equals(t, expected, result)
+		verify.Equals(t, fmt.Sprint(ids[1]), state["id"])
+		get, err := http.Get(otherServer.URL + "/replays/" + id)
+		verify.Ok(t, err)
+		get.Body.Close()
+		verify.Equals(t, http.StatusOK, get.StatusCode)
+	})
+
+	t.Run("Controlling unknown replay should return 404", func(t *testing.T) {
+		// action
+		res, _ := control(t, "abc", `{"paused":true}`)
+		get, err := http.Get(otherServer.URL + "/replays/abc")
+		// verify
+		verify.Ok(t, err)
+		get.Body.Close()
+		verify.Equals(t, http.StatusNotFound, res.StatusCode)
+		verify.Equals(t, http.StatusNotFound, get.StatusCode)
+	})
+
+	t.Run("Replaying unknown vehicle should return 404", func(t *testing.T) {
+		// action
+		res, err := http.Get(fmt.Sprintf("%s/vehicles/%d/replay", server.URL, vehicleID+1))
+		// verify
+		verify.Ok(t, err)
+		res.Body.Close()
+		verify.Equals(t, http.StatusNotFound, res.StatusCode)
+	})
+}
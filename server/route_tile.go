@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mimeMVT = "application/vnd.mapbox-vector-tile"
+
+const (
+	// mvtThinningMaxZoom is the zoom level from which tiles contain all states.
+	mvtThinningMaxZoom = 15
+	// mvtThinningCellSize is the grid cell size in tile units used below mvtThinningMaxZoom,
+	// 4 pixels of a tile rendered at 256 pixels.
+	mvtThinningCellSize = mvtExtent / 64
+)
+
+// getVehicleStateTile returns the vehicle states of a tile as Mapbox vector tile, optionally restricted
+// by the same query parameters as getVehicleStates, except for bbox. Below zoom level mvtThinningMaxZoom
+// only the newest state per grid cell is included. Tiles are validated by an ETag derived from the filters
+// and the states of the tile before it is generated, see tileETag. Empty tiles are returned as 204.
+func (srv ApplicationServer) getVehicleStateTile(c *gin.Context) {
+	y := c.Param("y")
+	if !strings.HasSuffix(y, ".mvt") {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	t, err := parseTile(c.Param("z"), c.Param("x"), strings.TrimSuffix(y, ".mvt"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := vehicleStateFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.BBox != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bbox is not supported, tiles are bounded already"})
+		return
+	}
+	cellSize := 0
+	if t.Z < mvtThinningMaxZoom {
+		cellSize = mvtThinningCellSize
+	}
+	count, maxID, err := getVehicleStateTileVersion(srv.logger, srv.db, t, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	etag := tileETag(t, c.Request.URL.Query().Encode(), count, maxID)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	data, err := getVehicleStateTile(srv.logger, srv.db, t, filter, cellSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(data) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Data(http.StatusOK, mimeMVT, data)
+}
+
+// tileETag returns a weak ETag of the tile for the given canonical query and its number of states
+// and highest state id, see getVehicleStateTileVersion. The encoding of equal tiles may differ in bytes.
+func tileETag(t tile, query string, count, maxID int64) string {
+	key := fmt.Sprintf("%d/%d/%d?%s#%d-%d", t.Z, t.X, t.Y, query, count, maxID)
+	return fmt.Sprintf(`W/"%x"`, sha1.Sum([]byte(key)))
+}
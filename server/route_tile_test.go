@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGetVehicleStateTileInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	var tests = []struct {
+		path     string
+		expected int
+	}{
+		{"/tiles/vehicleStates/10/568/422.png", http.StatusNotFound},
+		{"/tiles/vehicleStates/10/568/1024.mvt", http.StatusBadRequest},
+		{"/tiles/vehicleStates/10/568/422.mvt?from=yesterday", http.StatusBadRequest},
+		{"/tiles/vehicleStates/10/568/422.mvt?bbox=19,29,21,31", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			// arrange
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", test.path, nil)
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, test.expected, res.Code)
+		})
+	}
+}
+
+func TestTileETag(t *testing.T) {
+	// arrange
+	unit := tile{Z: 10, X: 568, Y: 422}
+	etag := tileETag(unit, "from=2021-06-15T00%3A00%3A00Z", 10, 42)
+	// action
+	others := []string{
+		tileETag(tile{Z: 10, X: 568, Y: 423}, "from=2021-06-15T00%3A00%3A00Z", 10, 42),
+		tileETag(unit, "", 10, 42),
+		tileETag(unit, "from=2021-06-15T00%3A00%3A00Z", 9, 42),
+		tileETag(unit, "from=2021-06-15T00%3A00%3A00Z", 10, 43),
+	}
+	// verify
+	verify.Equals(t, etag, tileETag(unit, "from=2021-06-15T00%3A00%3A00Z", 10, 42))
+	verify.Assert(t, strings.HasPrefix(etag, `W/"`), "etag %s is not weak", etag)
+	for _, other := range others {
+		verify.Assert(t, other != etag, "etag %s not changed", other)
+	}
+}
+
+func TestGetVehicleStateTileIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	states := make([]vehicleState, 10)
+	for i := range states {
+		states[i] = vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30 + float64(i)*0.00001}), Timestamp: start.Add(time.Duration(i) * time.Second)}
+	}
+	_, err := addVehicleStates(unit.logger, unit.db, states)
+	verify.Ok(t, err)
+
+	var etag string
+	t.Run("Getting tile", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tiles/vehicleStates/16/36408/27038.mvt", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/vnd.mapbox-vector-tile", res.Header().Get("Content-Type"))
+		verify.Assert(t, res.Body.Len() > 0, "tile is empty")
+		etag = res.Header().Get("ETag")
+		verify.Assert(t, etag != "", "no etag")
+	})
+
+	t.Run("Getting thinned tile", func(t *testing.T) {
+		// arrange
+		full := httptest.NewRecorder()
+		unit.router.ServeHTTP(full, httptest.NewRequest("GET", "/tiles/vehicleStates/16/36408/27038.mvt", nil))
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tiles/vehicleStates/10/568/422.mvt", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Assert(t, res.Body.Len() < full.Body.Len(), "tile is not thinned")
+	})
+
+	t.Run("Getting unchanged tile", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tiles/vehicleStates/16/36408/27038.mvt", nil)
+		req.Header.Set("If-None-Match", etag)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotModified, res.Code)
+	})
+
+	t.Run("Getting changed tile", func(t *testing.T) {
+		// arrange
+		_, err := addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: start})
+		verify.Ok(t, err)
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tiles/vehicleStates/16/36408/27038.mvt", nil)
+		req.Header.Set("If-None-Match", etag)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Assert(t, res.Header().Get("ETag") != etag, "etag not changed")
+	})
+
+	t.Run("Getting tile outside of time range", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tiles/vehicleStates/16/36408/27038.mvt?from=2021-06-16T00:00:00Z", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNoContent, res.Code)
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getTrips returns the trips and stops of a single vehicle, optionally restricted to from/to
+// and a type. Segments are updated with new states in the background, see maintainTrips.
+func (srv ApplicationServer) getTrips(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tripType := c.Query("type")
+	if tripType != "" && tripType != tripTypeTrip && tripType != tripTypeStop {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be trip or stop"})
+		return
+	}
+	_, err = getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	trips, err := getTrips(srv.logger, srv.db, tripFilter{VehicleID: id, Type: tripType, From: from, To: to})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Trips []trip `json:"trips"`
+	}{
+		Trips: trips,
+	}
+	c.JSON(http.StatusOK, res)
+}
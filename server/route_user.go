@@ -64,6 +64,24 @@ func (srv ApplicationServer) getUser(c *gin.Context) {
 }
 
 func (srv ApplicationServer) getUsers(c *gin.Context) {
+	format, err := negotiateFormat(c, gin.MIMEJSON, mimeCSV)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if format == mimeCSV {
+		opts, err := csvOptionsFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		srv.renderCSV(c, opts, userCSVHeader, func(write func([]string) error) error {
+			return forEachUser(srv.logger, srv.db, func(id int64, user user) error {
+				return write(userCSVRecord(id, user))
+			})
+		})
+		return
+	}
 	users, err := getUsers(srv.logger, srv.db)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -76,6 +76,18 @@ func TestCrudUserIntegration(t *testing.T) {
 		verify.Equals(t, 1, len(result.Users))
 	})
 
+	t.Run("Getting all users as CSV", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/users?format=csv&delimiter=%3B", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "text/csv; charset=utf-8", res.Header().Get("Content-Type"))
+		verify.Equals(t, fmt.Sprintf("id;name\n%d;testuser\n", id), res.Body.String())
+	})
+
 	t.Run("Deleting user by id", func(t *testing.T) {
 		// arrange
 		res := httptest.NewRecorder()
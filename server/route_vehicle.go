@@ -0,0 +1,237 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func (srv ApplicationServer) addVehicle(c *gin.Context) {
+	var vehicle vehicle
+	if err := c.ShouldBindJSON(&vehicle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := addVehicle(srv.logger, srv.db, vehicle)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		VehicleId int64 `json:"vehicleId"`
+	}{
+		VehicleId: id,
+	}
+	c.JSON(http.StatusCreated, res)
+}
+
+func (srv ApplicationServer) updateVehicle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var vehicle vehicle
+	if err := c.ShouldBindJSON(&vehicle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = updateVehicle(srv.logger, srv.db, id, vehicle)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (srv ApplicationServer) deleteVehicle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = deleteVehicle(srv.logger, srv.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (srv ApplicationServer) getVehicle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	retrievedVehicle, err := getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Vehicle vehicle `json:"vehicle"`
+	}{
+		Vehicle: retrievedVehicle,
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (srv ApplicationServer) getVehicles(c *gin.Context) {
+	vehicles, err := getVehicles(srv.logger, srv.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		Vehicles []vehicle `json:"vehicles"`
+	}{
+		Vehicles: vehicles,
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// getVehicleStatesOfVehicle returns the state history of a single vehicle.
+func (srv ApplicationServer) getVehicleStatesOfVehicle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := vehicleStateFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.VehicleID = id
+	srv.renderVehicleStates(c, filter)
+}
+
+// getTrajectory returns the states of a single vehicle as a GeoJSON feature,
+// see newTrajectory, or as KML gx:Track if requested. The states may be simplified, see simplifyStates.
+func (srv ApplicationServer) getTrajectory(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, err := negotiateFormat(c, mimeGeoJSON, mimeKML)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	simplify, err := simplifyOptionsFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	vehicle, err := getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := getVehicleStates(srv.logger, srv.db, vehicleStateFilter{VehicleID: id, From: from, To: to})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data, dropped := simplifyStates(data, simplify)
+	trajectory := newTrajectory(id, data)
+	if simplify.enabled() {
+		trajectory.Properties["droppedPoints"] = dropped
+		c.Header(headerDroppedPoints, strconv.Itoa(dropped))
+	}
+	if format == mimeKML {
+		trajectory.Properties["name"] = vehicle.Name
+		srv.renderKML(c, vehicle.Name, []*geojson.Feature{trajectory})
+		return
+	}
+	renderGeoJSON(c, http.StatusOK, trajectory)
+}
+
+// getLatestVehicleStates returns the newest state of each vehicle, optionally restricted
+// to a bbox. If staleAfter is given, states older than that duration are flagged as stale.
+func (srv ApplicationServer) getLatestVehicleStates(c *gin.Context) {
+	var bbox *orb.Bound
+	if value := c.Query("bbox"); value != "" {
+		b, err := parseBBox(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		bbox = &b
+	}
+	var staleAfter time.Duration
+	if value := c.Query("staleAfter"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "staleAfter must be a positive duration, e.g. 15m"})
+			return
+		}
+		staleAfter = d
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := getLatestVehicleStates(srv.logger, srv.db, bbox)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out, err := encodeLatestVehicleStates(newLatestVehicleStates(data, time.Now(), staleAfter), geometryFormat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		VehicleStates interface{} `json:"vehicleStates"`
+	}{
+		VehicleStates: out,
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// newLatestVehicleStates flags all states older than staleAfter, relative to now.
+// A staleAfter of zero flags no state.
+func newLatestVehicleStates(states []vehicleState, now time.Time, staleAfter time.Duration) []latestVehicleState {
+	latest := make([]latestVehicleState, len(states))
+	for i, state := range states {
+		latest[i] = latestVehicleState{
+			vehicleState: state,
+			Stale:        staleAfter > 0 && now.Sub(state.Timestamp) > staleAfter,
+		}
+	}
+	return latest
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestCrudVehicleIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+
+	var id int64
+	t.Run("Adding vehicle", func(t *testing.T) {
+		// arrange
+		testdata, _ := json.Marshal(vehicle{Name: "truck"})
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/vehicles", strings.NewReader(string(testdata)))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusCreated, res.Code)
+		result := struct {
+			VehicleId int64 `json:"vehicleId"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		id = result.VehicleId
+	})
+
+	t.Run("Adding vehicle without name should return 400", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/vehicles", strings.NewReader("{}"))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Updating vehicle", func(t *testing.T) {
+		// arrange
+		testdata, _ := json.Marshal(vehicle{Name: "truck 1"})
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/vehicles/%d", id), strings.NewReader(string(testdata)))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNoContent, res.Code)
+	})
+
+	t.Run("Getting vehicle by id", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			Vehicle vehicle `json:"vehicle"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, "truck 1", result.Vehicle.Name)
+	})
+
+	t.Run("Getting all vehicles", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicles", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			Vehicles []vehicle `json:"vehicles"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.Vehicles))
+	})
+
+	t.Run("Getting states of vehicle", func(t *testing.T) {
+		// arrange
+		addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: id, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)})
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/states", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleStates []vehicleState `json:"vehicleStates"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.VehicleStates))
+		verify.Equals(t, id, result.VehicleStates[0].VehicleID)
+	})
+
+	t.Run("Getting trajectory of vehicle", func(t *testing.T) {
+		// arrange
+		addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: id, Position: *geojson.NewGeometry(orb.Point{20, 31}), Timestamp: time.Date(2021, 6, 15, 9, 30, 0, 0, time.UTC)})
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/trajectory?from=2021-06-15T09:00:00Z&to=2021-06-15T10:00:00Z", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/geo+json", res.Header().Get("Content-Type"))
+		result, err := geojson.UnmarshalFeature(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, orb.LineString{{20, 30}, {20, 31}}, result.Geometry)
+		verify.Equals(t, 1800.0, result.Properties["duration"])
+	})
+
+	t.Run("Getting simplified trajectory of vehicle", func(t *testing.T) {
+		// arrange
+		between, _ := addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: id, Position: *geojson.NewGeometry(orb.Point{20, 30.5}), Timestamp: time.Date(2021, 6, 15, 9, 15, 0, 0, time.UTC)})
+		defer deleteVehicleState(unit.logger, unit.db, between)
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/trajectory?from=2021-06-15T09:00:00Z&to=2021-06-15T10:00:00Z&simplify=10", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "1", res.Header().Get(headerDroppedPoints))
+		result, err := geojson.UnmarshalFeature(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, orb.LineString{{20, 30}, {20, 31}}, result.Geometry)
+		verify.Equals(t, 1.0, result.Properties["droppedPoints"])
+	})
+
+	t.Run("Getting trajectory of vehicle as KML", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/trajectory?format=kml&lineWidth=5", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/vnd.google-earth.kml+xml", res.Header().Get("Content-Type"))
+		verify.Assert(t, strings.Contains(res.Body.String(), "<gx:coord>20 31 0</gx:coord>"), "track missing")
+		verify.Assert(t, strings.Contains(res.Body.String(), "<width>5</width>"), "style missing")
+	})
+
+	t.Run("Adding track as GPX", func(t *testing.T) {
+		// arrange
+		testdata := `<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1"><trk><trkseg>
+			<trkpt lat="31.5" lon="20"><ele>100</ele><time>2021-06-15T09:40:00Z</time></trkpt>
+			<trkpt lat="32" lon="20"></trkpt>
+		</trkseg></trk></gpx>`
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/vehicles/%d/track", id), strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusCreated, res.Code)
+		result := struct {
+			Created []batchCreated `json:"created"`
+			Errors  []batchError   `json:"errors"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.Created))
+		verify.Equals(t, 1, len(result.Errors))
+		verify.Equals(t, 1, result.Errors[0].Index)
+		defer deleteVehicleState(unit.logger, unit.db, result.Created[0].VehicleStateID)
+	})
+
+	t.Run("Getting track as GPX", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/track.gpx?from=2021-06-15T09:00:00Z&to=2021-06-15T10:00:00Z", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/gpx+xml", res.Header().Get("Content-Type"))
+		result, err := decodeGPXTrack(res.Body, id)
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result))
+	})
+
+	t.Run("Getting track of unknown vehicle should return 404", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/track.gpx", id+1), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("Getting latest positions", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicles/positions/latest?bbox=19,29,21,32&staleAfter=1h", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleStates []latestVehicleState `json:"vehicleStates"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.VehicleStates))
+		verify.Equals(t, orb.Point{20, 31}, result.VehicleStates[0].Position.Geometry())
+		verify.Equals(t, true, result.VehicleStates[0].Stale)
+	})
+
+	t.Run("Getting trips of vehicle", func(t *testing.T) {
+		// arrange
+		_, err := unit.updateTripsCommittedAfter(0)
+		verify.Ok(t, err)
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/trips?type=trip", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			Trips []trip `json:"trips"`
+		}{}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		for _, trip := range result.Trips {
+			verify.Equals(t, tripTypeTrip, trip.Type)
+		}
+	})
+
+	t.Run("Getting trips with unknown type should return 400", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/trips?type=parking", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Getting trips of unknown vehicle should return 404", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/trips", id+1), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("Getting distance of vehicle", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/distance?bucket=day", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := vehicleDistance{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, id, result.VehicleID)
+		sum := 0.0
+		for _, bucket := range result.Buckets {
+			sum += bucket.Distance
+		}
+		verify.Equals(t, result.Distance, sum)
+	})
+
+	t.Run("Getting distance of all vehicles", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicles/distance?minDistance=0&from=2021-06-15T00:00:00Z&to=2021-06-16T00:00:00Z", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			Distances []vehicleDistance `json:"distances"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.Distances))
+		verify.Equals(t, id, result.Distances[0].VehicleID)
+	})
+
+	t.Run("Getting distance of unknown vehicle should return 404", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/distance", id+1), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("Deleting vehicle by id", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/vehicles/%d", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNoContent, res.Code)
+	})
+
+	t.Run("Getting states of deleted vehicle should return 404", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/vehicles/%d/states", id), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestNewLatestVehicleStates(t *testing.T) {
+	// arrange
+	now := time.Date(2021, 6, 15, 10, 0, 0, 0, time.UTC)
+	states := []vehicleState{
+		{VehicleID: 1, Timestamp: now.Add(-5 * time.Minute)},
+		{VehicleID: 2, Timestamp: now.Add(-time.Hour)},
+	}
+	// action
+	result := newLatestVehicleStates(states, now, 15*time.Minute)
+	unflagged := newLatestVehicleStates(states, now, 0)
+	// verify
+	verify.Equals(t, false, result[0].Stale)
+	verify.Equals(t, true, result[1].Stale)
+	verify.Equals(t, false, unflagged[1].Stale)
+}
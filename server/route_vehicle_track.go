@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getTrackGPX returns the states of a single vehicle as GPX 1.1 track,
+// optionally restricted to a time range.
+func (srv ApplicationServer) getTrackGPX(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	vehicle, err := getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := getVehicleStates(srv.logger, srv.db, vehicleStateFilter{VehicleID: id, From: from, To: to})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", mimeGPX)
+	err = writeGPX(c.Writer, newGPX(vehicle, data))
+	if err != nil {
+		srv.logger.Printf("Aborted GPX export: %v\n", err)
+	}
+}
+
+// addTrack stores the track points of an uploaded GPX document as states of a single vehicle.
+// The response lists the created states and rejected points by their position in the document.
+// Documents are limited like batches, see maxBatchBodySize and maxBatchSize.
+func (srv ApplicationServer) addTrack(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = getVehicle(srv.logger, srv.db, id)
+	if err == ErrorNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tooLarge := limitRequestBody(c, maxBatchBodySize)
+	items, err := decodeGPXTrack(c.Request.Body, id)
+	if tooLarge() {
+		abortBodyTooLarge(c, maxBatchBodySize)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no track points given"})
+		return
+	}
+	created, errs, err := srv.storeVehicleStateBatch(items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	status := http.StatusCreated
+	if len(created) == 0 {
+		status = http.StatusBadRequest
+	}
+	res := struct {
+		Created []batchCreated `json:"created"`
+		Errors  []batchError   `json:"errors"`
+	}{
+		Created: created,
+		Errors:  errs,
+	}
+	c.JSON(status, res)
+}
@@ -1,20 +1,46 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
 )
 
+// validateVehicleState checks the parts of a state that are not covered by its binding tags.
+func validateVehicleState(state vehicleState) error {
+	position, ok := state.Position.Geometry().(orb.Point)
+	if !ok {
+		return errors.New("position must be a point")
+	}
+	if err := checkLonLat(position); err != nil {
+		return err
+	}
+	if state.Timestamp.IsZero() {
+		return errors.New("timestamp is required")
+	}
+	return nil
+}
+
 func (srv ApplicationServer) addVehicleState(c *gin.Context) {
 	var data vehicleState
 	if err := c.ShouldBindJSON(&data); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	id, err := addVehicleState(srv.logger, srv.db, data.Position.Geometry().(orb.Point), data.Timestamp)
+	if err := validateVehicleState(data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := addVehicleState(srv.logger, srv.db, data)
+	if err == ErrorUnknownVehicle {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -47,6 +73,11 @@ func (srv ApplicationServer) getVehicleState(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	data, err := getVehicleState(srv.logger, srv.db, id)
 	if err == ErrorNotFound {
 		c.Status(http.StatusNotFound)
@@ -56,24 +87,199 @@ func (srv ApplicationServer) getVehicleState(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	out, err := encodeVehicleState(data, geometryFormat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	res := struct {
-		VehicleState vehicleState `json:"vehicleState"`
+		VehicleState interface{} `json:"vehicleState"`
 	}{
-		VehicleState: data,
+		VehicleState: out,
 	}
 	c.JSON(http.StatusOK, res)
 }
 
+// vehicleStateFilterFromQuery reads the filter parameters supported by vehicle state lists.
+func vehicleStateFilterFromQuery(c *gin.Context) (vehicleStateFilter, error) {
+	var filter vehicleStateFilter
+	if value := c.Query("bbox"); value != "" {
+		bbox, err := parseBBox(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.BBox = &bbox
+	}
+	from, to, err := parseTimeRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		return filter, err
+	}
+	filter.From, filter.To = from, to
+	if value := c.Query("attributes"); value != "" {
+		if err := json.Unmarshal([]byte(value), &filter.Attributes); err != nil {
+			return filter, errors.New("attributes must be a JSON object")
+		}
+	}
+	filter.Descending, err = parseOrder(c.Query("order"))
+	return filter, err
+}
+
 func (srv ApplicationServer) getVehicleStates(c *gin.Context) {
-	data, err := getVehicleStates(srv.logger, srv.db)
+	filter, err := vehicleStateFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	srv.renderVehicleStates(c, filter)
+}
+
+// renderVehicleStates writes the vehicle states matching filter, as JSON object by default.
+// GeoJSON feature collections and CSV are returned if requested by the Accept header
+// or the format query parameter, CSV is streamed while it is read from the database.
+// If simplification is requested, see simplifyStates, the number of dropped states is reported.
+func (srv ApplicationServer) renderVehicleStates(c *gin.Context, filter vehicleStateFilter) {
+	format, err := negotiateFormat(c, gin.MIMEJSON, mimeGeoJSON, mimeCSV)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	simplify, err := simplifyOptionsFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var opts csvOptions
+	if format == mimeCSV {
+		opts, err = csvOptionsFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if format == mimeCSV && !simplify.enabled() {
+		srv.renderCSV(c, opts, vehicleStateCSVHeader, func(write func([]string) error) error {
+			return forEachVehicleState(srv.logger, srv.db, filter, func(state vehicleState) error {
+				record, err := vehicleStateCSVRecord(state, opts.Location)
+				if err != nil {
+					return err
+				}
+				return write(record)
+			})
+		})
+		return
+	}
+
+	data, err := getVehicleStates(srv.logger, srv.db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var dropped *int
+	if simplify.enabled() {
+		var n int
+		data, n = simplifyStates(data, simplify)
+		dropped = &n
+		c.Header(headerDroppedPoints, strconv.Itoa(n))
+	}
+	switch format {
+	case mimeCSV:
+		srv.renderCSV(c, opts, vehicleStateCSVHeader, func(write func([]string) error) error {
+			for _, state := range data {
+				record, err := vehicleStateCSVRecord(state, opts.Location)
+				if err == nil {
+					err = write(record)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	case mimeGeoJSON:
+		collection, err := newVehicleStateFeatureCollection(data)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if dropped != nil {
+			collection.ExtraMembers = geojson.Properties{"droppedPoints": *dropped}
+		}
+		renderGeoJSON(c, http.StatusOK, collection)
+		return
+	}
+	out, err := encodeVehicleStates(data, geometryFormat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := struct {
+		VehicleStates interface{} `json:"vehicleStates"`
+		DroppedPoints *int        `json:"droppedPoints,omitempty"`
+	}{
+		VehicleStates: out,
+		DroppedPoints: dropped,
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// getNearbyVehicleStates returns vehicle states ordered by their distance to lon/lat.
+// Either radius (metres), k (number of results) or both must be given.
+func (srv ApplicationServer) getNearbyVehicleStates(c *gin.Context) {
+	center, err := parseLonLat(c.Query("lon"), c.Query("lat"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var radius float64
+	if value := c.Query("radius"); value != "" {
+		radius, err = strconv.ParseFloat(value, 64)
+		if err != nil || radius <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "radius must be a positive number"})
+			return
+		}
+	}
+	var k int
+	if value := c.Query("k"); value != "" {
+		k, err = strconv.Atoi(value)
+		if err != nil || k <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "k must be a positive integer"})
+			return
+		}
+	}
+	if radius == 0 && k == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "radius or k is required"})
+		return
+	}
+	filter, err := vehicleStateFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := getNearbyVehicleStates(srv.logger, srv.db, filter, center, radius, k)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out, err := encodeNearbyVehicleStates(data, geometryFormat)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	res := struct {
-		VehicleStates []vehicleState `json:"vehicleStates"`
+		VehicleStates interface{} `json:"vehicleStates"`
 	}{
-		VehicleStates: data,
+		VehicleStates: out,
 	}
 	c.JSON(http.StatusOK, res)
 }
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minGridCellSize is the smallest cell size in metres accepted for aggregation.
+const minGridCellSize = 1.0
+
+// getVehicleStateAggregate counts the vehicle states matching the query parameters of getVehicleStates
+// in a square grid of cellSize metres, returned as GeoJSON polygons. The grid is laid out in web mercator,
+// cells are cellSize metres wide at the centre latitude of bbox, or of the whole world without bbox.
+// Grids of more than maxGridCells cells are rejected.
+// With bucket=hour|day cells are counted per hour or day, starting in time zone tz. Bucketing requires
+// from and to, and the cells of all buckets together must not exceed maxGridCells.
+func (srv ApplicationServer) getVehicleStateAggregate(c *gin.Context) {
+	filter, err := vehicleStateFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cellSize, err := strconv.ParseFloat(c.Query("cellSize"), 64)
+	if err != nil || cellSize < minGridCellSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cellSize must be a number of at least 1 metre"})
+		return
+	}
+	bucket := c.Query("bucket")
+	if bucket != "" && bucket != timeBucketHour && bucket != timeBucketDay {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be hour or day"})
+		return
+	}
+	loc := time.UTC
+	if tz := c.Query("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if bucket != "" && (filter.From == nil || filter.To == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket requires from and to"})
+		return
+	}
+	extent := gridExtent(filter.BBox)
+	mercatorCellSize := gridCellSize(extent, cellSize)
+	count := gridCellCount(extent, mercatorCellSize)
+	if bucket != "" {
+		count *= timeBucketCount(*filter.From, *filter.To, bucket)
+	}
+	if count > maxGridCells {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("grid must not exceed %d cells, increase cellSize or restrict bbox or time range", maxGridCells)})
+		return
+	}
+	cells, err := getVehicleStateGrid(srv.logger, srv.db, filter, mercatorCellSize, bucket, loc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	renderGeoJSON(c, http.StatusOK, newGridFeatureCollection(cells, mercatorCellSize))
+}
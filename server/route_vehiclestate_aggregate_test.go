@@ -0,0 +1,140 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGetVehicleStateAggregateInvalidQuery(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	for _, query := range []string{"", "cellSize=0.5", "cellSize=100&bucket=week", "cellSize=100&tz=Mars/Olympus", "cellSize=100&bbox=1,2", "cellSize=1000", "cellSize=1&bbox=19,29,21,31", "cellSize=1000&bbox=170,29,-170,31", "cellSize=1000&bbox=19,29,20,30&bucket=hour", "cellSize=1000&bbox=19,29,20,30&bucket=hour&from=2021-06-15T00:00:00Z&to=2021-06-17T00:00:00Z"} {
+		t.Run(query, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/vehicleStates/aggregate?"+query, nil)
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusBadRequest, res.Code)
+		})
+	}
+}
+
+func TestTimeBucketCount(t *testing.T) {
+	from := time.Date(2021, 6, 15, 0, 30, 0, 0, time.UTC)
+	// action
+	hours := timeBucketCount(from, from.Add(2*time.Hour), timeBucketHour)
+	days := timeBucketCount(from, from.Add(2*time.Hour), timeBucketDay)
+	empty := timeBucketCount(from, from.Add(-time.Hour), timeBucketHour)
+	// verify
+	verify.Equals(t, 4.0, hours)
+	verify.Equals(t, 2.0, days)
+	verify.Equals(t, 2.0, empty)
+}
+
+func TestGridCellSize(t *testing.T) {
+	t.Run("Equator", func(t *testing.T) {
+		// arrange
+		extent := gridExtent(&orb.Bound{Min: orb.Point{-1, -1}, Max: orb.Point{1, 1}})
+		// action
+		size := gridCellSize(extent, 1000)
+		// verify
+		verify.Assert(t, math.Abs(size-1000) < 0.01, "unexpected cell size %v", size)
+	})
+
+	t.Run("Stretched towards the poles", func(t *testing.T) {
+		// arrange
+		extent := gridExtent(&orb.Bound{Min: orb.Point{10, 59.9}, Max: orb.Point{10.2, 60.1}})
+		// action
+		size := gridCellSize(extent, 1000)
+		// verify
+		verify.Assert(t, math.Abs(size-2000) < 1, "unexpected cell size %v", size)
+	})
+
+	t.Run("Crossing the antimeridian", func(t *testing.T) {
+		// arrange
+		extent := gridExtent(&orb.Bound{Min: orb.Point{179, -1}, Max: orb.Point{-179, 1}})
+		// action
+		count := gridCellCount(extent, gridCellSize(extent, 100000))
+		// verify
+		verify.Equals(t, 12.0, count)
+	})
+}
+
+func TestGetVehicleStateAggregateIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	for i, p := range []orb.Point{{20, 30}, {20.0001, 30.0001}, {21, 31}} {
+		addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(p), Timestamp: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	t.Run("Aggregating states", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates/aggregate?cellSize=1000&bbox=19,29,22,32", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/geo+json", res.Header().Get("Content-Type"))
+		result, err := geojson.UnmarshalFeatureCollection(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result.Features))
+		verify.Equals(t, 2.0, result.Features[0].Properties["count"])
+		verify.Assert(t, result.Features[0].Geometry.Bound().Contains(orb.Point{20, 30}), "cell does not contain states")
+	})
+
+	t.Run("Aggregating states by hour", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates/aggregate?cellSize=1000&bbox=19,29,22,32&from=2021-06-15T00:00:00Z&to=2021-06-16T00:00:00Z&bucket=hour&tz=Asia/Kolkata", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result, err := geojson.UnmarshalFeatureCollection(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, 3, len(result.Features))
+		verify.Equals(t, "2021-06-15T14:00:00+05:30", result.Features[0].Properties["start"])
+	})
+
+	t.Run("Aggregating states by hour when daylight saving time ends", func(t *testing.T) {
+		// arrange
+		for _, hour := range []int{0, 1} {
+			// 02:30 CEST and 02:30 CET
+			addVehicleState(unit.logger, unit.db, vehicleState{VehicleID: vehicleID, Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: time.Date(2021, 10, 31, hour, 30, 0, 0, time.UTC)})
+		}
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates/aggregate?cellSize=1000&bbox=19,29,22,32&from=2021-10-31T00:00:00Z&to=2021-10-31T02:00:00Z&bucket=hour&tz=Europe/Berlin", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result, err := geojson.UnmarshalFeatureCollection(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result.Features))
+		verify.Equals(t, "2021-10-31T02:00:00+02:00", result.Features[0].Properties["start"])
+		verify.Equals(t, "2021-10-31T02:00:00+01:00", result.Features[1].Properties["start"])
+	})
+}
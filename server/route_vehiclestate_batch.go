@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/paulmach/orb/geojson"
+)
+
+// maxBatchSize is the maximum number of vehicle states accepted by a single batch request.
+const maxBatchSize = 10000
+
+// maxBatchBodySize is the maximum size in bytes of the body of a batch request.
+const maxBatchBodySize = 16 << 20
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// limitRequestBody limits the request body to limit bytes with http.MaxBytesReader.
+// The returned function reports whether reading failed because the body is larger.
+func limitRequestBody(c *gin.Context, limit int64) func() bool {
+	body := &countingBody{ReadCloser: c.Request.Body}
+	c.Request.Body = http.MaxBytesReader(c.Writer, body, limit)
+	return func() bool {
+		// MaxBytesReader reads one byte beyond the limit to detect larger bodies
+		return body.n > limit
+	}
+}
+
+// abortBodyTooLarge responds that the request body exceeds limit bytes.
+func abortBodyTooLarge(c *gin.Context, limit int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body must not exceed %d bytes", limit)})
+}
+
+// batchItem is a single vehicle state of a batch, together with its position
+// in the batch and the reason it was rejected, if so.
+type batchItem struct {
+	index int
+	state vehicleState
+	err   error
+}
+
+// batchCreated reports the id of a stored vehicle state.
+type batchCreated struct {
+	Index          int   `json:"index"`
+	VehicleStateID int64 `json:"vehicleStateId"`
+}
+
+// batchError reports why a vehicle state was rejected.
+type batchError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// decodeVehicleStateBatch decodes either a JSON array of vehicle states or a GeoJSON
+// FeatureCollection of Point features. Each item is validated individually;
+// an error is only returned if the batch as a whole cannot be decoded.
+func decodeVehicleStateBatch(body []byte) ([]batchItem, error) {
+	var raw []json.RawMessage
+	var decode func(data []byte) (vehicleState, error)
+
+	body = bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(body, []byte("[")):
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+		decode = func(data []byte) (vehicleState, error) {
+			var state vehicleState
+			err := json.Unmarshal(data, &state)
+			return state, err
+		}
+	case bytes.HasPrefix(body, []byte("{")):
+		collection := struct {
+			Type     string            `json:"type"`
+			Features []json.RawMessage `json:"features"`
+		}{}
+		if err := json.Unmarshal(body, &collection); err != nil {
+			return nil, err
+		}
+		if collection.Type != "FeatureCollection" {
+			return nil, errors.New("expected a FeatureCollection")
+		}
+		raw = collection.Features
+		decode = func(data []byte) (vehicleState, error) {
+			feature, err := geojson.UnmarshalFeature(data)
+			if err != nil {
+				return vehicleState{}, err
+			}
+			return vehicleStateFromFeature(feature)
+		}
+	default:
+		return nil, errors.New("expected a JSON array or a GeoJSON FeatureCollection")
+	}
+	if len(raw) > maxBatchSize {
+		return nil, fmt.Errorf("batch must not contain more than %d vehicle states", maxBatchSize)
+	}
+
+	items := make([]batchItem, len(raw))
+	for i, data := range raw {
+		state, err := decode(data)
+		items[i] = newBatchItem(i, state, err)
+	}
+	return items, nil
+}
+
+// newBatchItem validates a decoded vehicle state, unless decoding already failed with err.
+func newBatchItem(index int, state vehicleState, err error) batchItem {
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&state)
+	}
+	if err == nil {
+		err = validateVehicleState(state)
+	}
+	return batchItem{index: index, state: state, err: err}
+}
+
+// storeVehicleStateBatch stores all valid items of the batch. Items referencing
+// unknown vehicles are rejected. The returned slices report the outcome of each item.
+func (srv ApplicationServer) storeVehicleStateBatch(items []batchItem) ([]batchCreated, []batchError, error) {
+	var vehicleIDs []int64
+	for _, item := range items {
+		if item.err == nil {
+			vehicleIDs = append(vehicleIDs, item.state.VehicleID)
+		}
+	}
+	existing, err := getExistingVehicleIDs(srv.logger, srv.db, vehicleIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var valid []batchItem
+	var states []vehicleState
+	errs := make([]batchError, 0)
+	for _, item := range items {
+		if item.err == nil && !existing[item.state.VehicleID] {
+			item.err = ErrorUnknownVehicle
+		}
+		if item.err != nil {
+			errs = append(errs, batchError{Index: item.index, Error: item.err.Error()})
+			continue
+		}
+		valid = append(valid, item)
+		states = append(states, item.state)
+	}
+
+	ids, err := addVehicleStates(srv.logger, srv.db, states)
+	if err != nil {
+		return nil, nil, err
+	}
+	created := make([]batchCreated, len(ids))
+	for i, id := range ids {
+		created[i] = batchCreated{Index: valid[i].index, VehicleStateID: id}
+	}
+	return created, errs, nil
+}
+
+// vehicleStatesAction dispatches custom methods on the vehicle state collection,
+// e.g. POST /vehicleStates:batch. The router cannot match a literal colon, so the action
+// parameter holds the rest of the segment after /vehicleStates, including the colon.
+// Anything else, like /vehicleStatesbatch, is not found.
+func (srv ApplicationServer) vehicleStatesAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":batch":
+		srv.addVehicleStates(c)
+	default:
+		c.Status(http.StatusNotFound)
+	}
+}
+
+// addVehicleStates stores a batch of vehicle states, given as JSON array or
+// GeoJSON FeatureCollection. Invalid items are reported but do not prevent
+// the others from being stored. Bodies larger than maxBatchBodySize are rejected.
+// Newline delimited JSON is streamed, see addVehicleStatesNDJSON.
+func (srv ApplicationServer) addVehicleStates(c *gin.Context) {
+	if c.ContentType() == mimeNDJSON {
+		srv.addVehicleStatesNDJSON(c)
+		return
+	}
+	tooLarge := limitRequestBody(c, maxBatchBodySize)
+	body, err := c.GetRawData()
+	if tooLarge() {
+		abortBodyTooLarge(c, maxBatchBodySize)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, err := decodeVehicleStateBatch(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no vehicle states given"})
+		return
+	}
+	created, errs, err := srv.storeVehicleStateBatch(items)
+	if err == ErrorUnknownVehicle {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	status := http.StatusCreated
+	if len(created) == 0 {
+		status = http.StatusBadRequest
+	}
+	res := struct {
+		Created []batchCreated `json:"created"`
+		Errors  []batchError   `json:"errors"`
+	}{
+		Created: created,
+		Errors:  errs,
+	}
+	c.JSON(status, res)
+}
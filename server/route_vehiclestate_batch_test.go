@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+)
+
+func TestDecodeVehicleStateBatch(t *testing.T) {
+	t.Run("array", func(t *testing.T) {
+		// arrange
+		testdata := `[
+			{"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}},
+			{"vehicleId": 1, "timestamp": "2021-06-15T09:01:00Z", "position": {"type": "LineString", "coordinates": [[20, 30], [21, 30]]}},
+			{"timestamp": "2021-06-15T09:02:00Z", "position": {"type": "Point", "coordinates": [20, 30]}},
+			{"vehicleId": "one"}
+		]`
+		// action
+		result, err := decodeVehicleStateBatch([]byte(testdata))
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 4, len(result))
+		verify.Ok(t, result[0].err)
+		verify.Equals(t, orb.Point{20, 30}, result[0].state.Position.Geometry())
+		for _, item := range result[1:] {
+			verify.Assert(t, item.err != nil, "expected error for item %d", item.index)
+		}
+	})
+
+	t.Run("feature collection", func(t *testing.T) {
+		// arrange
+		testdata := `{
+			"type": "FeatureCollection",
+			"features": [
+				{"type": "Feature", "geometry": {"type": "Point", "coordinates": [20, 30]}, "properties": {"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z"}},
+				{"type": "Feature", "geometry": null, "properties": {"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z"}}
+			]
+		}`
+		// action
+		result, err := decodeVehicleStateBatch([]byte(testdata))
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result))
+		verify.Ok(t, result[0].err)
+		verify.Equals(t, int64(1), result[0].state.VehicleID)
+		verify.Assert(t, result[1].err != nil, "expected error for feature without geometry")
+	})
+
+	for _, testdata := range []string{"", "42", `{"type": "Feature"}`, "[1,"} {
+		t.Run("invalid "+testdata, func(t *testing.T) {
+			// action
+			_, err := decodeVehicleStateBatch([]byte(testdata))
+			// verify
+			verify.Assert(t, err != nil, "expected error for %q", testdata)
+		})
+	}
+}
+
+func TestVehicleStatesUnknownAction(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	for _, path := range []string{"/vehicleStates:merge", "/vehicleStatesbatch", "/vehicleStates::batch"} {
+		t.Run(path, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", path, strings.NewReader("[]"))
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusNotFound, res.Code)
+		})
+	}
+}
+
+func TestAddVehicleStateBatchTooLarge(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/vehicleStates:batch", strings.NewReader("["+strings.Repeat(" ", maxBatchBodySize)+"]"))
+	req.Header.Set("Content-Type", "application/json")
+	// action
+	unit.router.ServeHTTP(res, req)
+	// verify
+	verify.Equals(t, http.StatusRequestEntityTooLarge, res.Code)
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	for name, test := range map[string]struct {
+		body     string
+		expected bool
+	}{
+		"smaller": {"abc", false},
+		"limit":   {"abcd", false},
+		"larger":  {"abcde", true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// arrange
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+			// action
+			tooLarge := limitRequestBody(c, 4)
+			_, err := c.GetRawData()
+			// verify
+			verify.Equals(t, test.expected, tooLarge())
+			verify.Equals(t, test.expected, err != nil)
+		})
+	}
+}
+
+func TestAddVehicleStateBatchIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
+
+	t.Run("Adding batch", func(t *testing.T) {
+		// arrange
+		testdata := fmt.Sprintf(`[
+			{"vehicleId": %[1]d, "timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}, "attributes": {"door": "open"}},
+			{"vehicleId": %[2]d, "timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}},
+			{"vehicleId": %[1]d, "timestamp": "2021-06-15T09:01:00Z", "position": {"type": "Point", "coordinates": [20, 31]}, "speed": 10}
+		]`, vehicleID, vehicleID+1)
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/vehicleStates:batch", strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusCreated, res.Code)
+		result := struct {
+			Created []batchCreated `json:"created"`
+			Errors  []batchError   `json:"errors"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(result.Created))
+		verify.Equals(t, 0, result.Created[0].Index)
+		verify.Equals(t, 2, result.Created[1].Index)
+		verify.Equals(t, []batchError{{Index: 1, Error: ErrorUnknownVehicle.Error()}}, result.Errors)
+
+		stored, err := getVehicleState(unit.logger, unit.db, result.Created[1].VehicleStateID)
+		verify.Ok(t, err)
+		verify.Equals(t, orb.Point{20, 31}, stored.Position.Geometry())
+		verify.Equals(t, 10.0, *stored.Speed)
+	})
+
+	t.Run("Adding batch without valid states should return 400", func(t *testing.T) {
+		// arrange
+		testdata := `{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": null, "properties": {}}]}`
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/vehicleStates:batch", strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+}
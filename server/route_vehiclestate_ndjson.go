@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mimeNDJSON = "application/x-ndjson"
+
+const (
+	// ndjsonChunkSize is the number of lines stored per transaction.
+	ndjsonChunkSize = 1000
+	// maxNDJSONLineSize is the maximum length of a single line in bytes.
+	maxNDJSONLineSize = 1 << 20
+	// maxNDJSONReportedErrors limits the number of rejected lines listed in the response.
+	maxNDJSONReportedErrors = 1000
+)
+
+// ndjsonError reports why a line was rejected.
+type ndjsonError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ndjsonResult is the outcome of a streamed import.
+// Error is set if the import was aborted; lines accepted before are kept.
+type ndjsonResult struct {
+	Accepted      int           `json:"accepted"`
+	RejectedCount int           `json:"rejectedCount"`
+	Rejected      []ndjsonError `json:"rejected"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// ndjsonReadError is returned by importNDJSON if the input could not be read,
+// e.g. because the client was too slow or disconnected.
+type ndjsonReadError struct {
+	err error
+}
+
+func (e *ndjsonReadError) Error() string {
+	return fmt.Sprintf("reading request body: %v", e.err)
+}
+
+func (e *ndjsonReadError) Unwrap() error {
+	return e.err
+}
+
+// importNDJSON reads one vehicle state per line from r and passes them on to store
+// in chunks of chunkSize. Blank lines are skipped, line numbers start at 1.
+// Reading stops at the first error returned by store or encountered while reading,
+// the latter is returned as ndjsonReadError.
+func importNDJSON(r io.Reader, chunkSize int, store func([]batchItem) ([]batchCreated, []batchError, error)) (ndjsonResult, error) {
+	result := ndjsonResult{Rejected: make([]ndjsonError, 0)}
+	reject := func(line int, reason string) {
+		result.RejectedCount++
+		if len(result.Rejected) < maxNDJSONReportedErrors {
+			result.Rejected = append(result.Rejected, ndjsonError{Line: line, Error: reason})
+		}
+	}
+
+	var chunk []batchItem
+	flush := func() error {
+		created, errs, err := store(chunk)
+		if err != nil {
+			return err
+		}
+		result.Accepted += len(created)
+		for _, e := range errs {
+			reject(e.Index, e.Error)
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var state vehicleState
+		err := json.Unmarshal(data, &state)
+		chunk = append(chunk, newBatchItem(line, state, err))
+		if len(chunk) >= chunkSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, &ndjsonReadError{err: err}
+	}
+	if len(chunk) > 0 {
+		return result, flush()
+	}
+	return result, nil
+}
+
+// addVehicleStatesNDJSON stores newline delimited vehicle states while the request body
+// is still being received, committing every ndjsonChunkSize lines. The response reports
+// the number of accepted lines and the rejected lines with their reasons. If the import
+// is aborted, the lines accepted up to then remain stored. Instead of the server wide read timeout,
+// each read of the body may take streamReadTimeout.
+func (srv ApplicationServer) addVehicleStatesNDJSON(c *gin.Context) {
+	result, err := importNDJSON(deadlineReader{c: c, r: c.Request.Body}, ndjsonChunkSize, srv.storeVehicleStateBatch)
+	extendWriteDeadline(c)
+	if err != nil {
+		srv.logger.Printf("Aborted import of vehicle states after %d lines: %v\n", result.Accepted, err)
+		result.Error = err.Error()
+		c.JSON(ndjsonErrorStatus(err), result)
+		return
+	}
+	status := http.StatusCreated
+	if result.Accepted == 0 {
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, result)
+}
+
+// ndjsonErrorStatus returns the response status of an aborted import: 408 if the body was not received in time,
+// 400 for other errors reading the body and 500 if the states could not be stored.
+func ndjsonErrorStatus(err error) int {
+	var readErr *ndjsonReadError
+	if !errors.As(err, &readErr) {
+		return http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(readErr.err, &netErr) && netErr.Timeout() {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
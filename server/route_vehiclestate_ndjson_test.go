@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+)
+
+func TestImportNDJSON(t *testing.T) {
+	testdata := strings.Join([]string{
+		`{"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}}`,
+		``,
+		`{"vehicleId": 1, "timestamp": "2021-06-15T09:01:00Z"`,
+		`{"vehicleId": 1, "timestamp": "2021-06-15T09:02:00Z", "position": {"type": "Point", "coordinates": [20, 30]}}`,
+		`{"vehicleId": 1, "timestamp": "2021-06-15T09:03:00Z", "position": {"type": "Point", "coordinates": [20, 30]}}`,
+	}, "\n")
+	// store accepts every valid item
+	store := func(items []batchItem) ([]batchCreated, []batchError, error) {
+		created, errs := []batchCreated{}, []batchError{}
+		for _, item := range items {
+			if item.err != nil {
+				errs = append(errs, batchError{Index: item.index, Error: item.err.Error()})
+			} else {
+				created = append(created, batchCreated{Index: item.index})
+			}
+		}
+		return created, errs, nil
+	}
+
+	t.Run("partial success", func(t *testing.T) {
+		// arrange
+		var chunks []int
+		counting := func(items []batchItem) ([]batchCreated, []batchError, error) {
+			chunks = append(chunks, len(items))
+			return store(items)
+		}
+		// action
+		result, err := importNDJSON(strings.NewReader(testdata), 2, counting)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, 3, result.Accepted)
+		verify.Equals(t, 1, result.RejectedCount)
+		verify.Equals(t, 3, result.Rejected[0].Line)
+		verify.Equals(t, []int{2, 2}, chunks)
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		// arrange
+		calls := 0
+		failing := func(items []batchItem) ([]batchCreated, []batchError, error) {
+			calls++
+			if calls > 1 {
+				return nil, nil, errors.New("connection lost")
+			}
+			return store(items)
+		}
+		// action
+		result, err := importNDJSON(strings.NewReader(testdata), 2, failing)
+		// verify
+		verify.Assert(t, err != nil, "expected error")
+		verify.Equals(t, 1, result.Accepted)
+		verify.Equals(t, 1, result.RejectedCount)
+	})
+
+	t.Run("line too long", func(t *testing.T) {
+		// arrange
+		long := strings.Repeat(" ", maxNDJSONLineSize+1)
+		// action
+		_, err := importNDJSON(strings.NewReader(long), 2, store)
+		// verify
+		verify.Assert(t, errors.Is(err, bufio.ErrTooLong), "unexpected error %v", err)
+		verify.Equals(t, http.StatusBadRequest, ndjsonErrorStatus(err))
+	})
+}
+
+func TestNDJSONErrorStatus(t *testing.T) {
+	for _, testdata := range []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"read timeout", &ndjsonReadError{err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}}, http.StatusRequestTimeout},
+		{"disconnect", &ndjsonReadError{err: io.ErrUnexpectedEOF}, http.StatusBadRequest},
+		{"database", errors.New("connection lost"), http.StatusInternalServerError},
+	} {
+		t.Run(testdata.name, func(t *testing.T) {
+			// action
+			status := ndjsonErrorStatus(testdata.err)
+			// verify
+			verify.Equals(t, testdata.expected, status)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mimeEventStream = "text/event-stream"
+	// eventVehicleState is the SSE event name of vehicle states.
+	eventVehicleState = "vehicleState"
+	// streamBufferSize is the number of states buffered for a stream before it is closed as lagging.
+	streamBufferSize = 256
+	// streamKeepAlive is the interval of comments sent to keep idle streams open.
+	streamKeepAlive = 15 * time.Second
+)
+
+// subscriptionFilterFromQuery reads the optional query parameters vehicleIds (comma separated) and bbox.
+func subscriptionFilterFromQuery(c *gin.Context) (subscriptionFilter, error) {
+	var filter subscriptionFilter
+	if value := c.Query("vehicleIds"); value != "" {
+		ids, err := parseIDList(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.VehicleIDs = ids
+	}
+	if value := c.Query("bbox"); value != "" {
+		bbox, err := parseBBox(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.BBox = &bbox
+	}
+	return filter, nil
+}
+
+// streamVehicleStates pushes newly stored vehicle states as server-sent events, optionally
+// restricted to vehicleIds and bbox. The id of each event is the commitCursor of the state. A client
+// connecting without Last-Event-ID receives the current cursor first, as an event without data.
+// If the client reconnects with Last-Event-ID, the states committed since are sent from the database first.
+// Streams of clients that do not keep up are closed, so that they resume from the database.
+func (srv ApplicationServer) streamVehicleStates(c *gin.Context) {
+	filter, err := subscriptionFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	geometryFormat, err := geometryFormatFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var lastEventID *commitCursor
+	if value := c.GetHeader("Last-Event-ID"); value != "" {
+		cursor, err := parseCommitCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be the id of a vehicle state event"})
+			return
+		}
+		lastEventID = &cursor
+	}
+
+	// subscribe before reading the backlog, so that no state is missed in between
+	sub := newStateSubscription(filter, streamBufferSize)
+	position := srv.broker.subscribe(sub)
+	defer srv.broker.unsubscribe(sub)
+	var last commitCursor
+	if lastEventID != nil {
+		last = *lastEventID
+	} else if position != nil {
+		last = *position
+	} else {
+		// the listener has not started yet
+		last, err = getLastCommitCursor(srv.logger, srv.db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	startStream(c)
+	c.Header("Content-Type", mimeEventStream)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	write := func(state vehicleState) error {
+		data, err := encodeVehicleState(state, geometryFormat)
+		if err != nil {
+			return err
+		}
+		extendWriteDeadline(c)
+		err = sse.Encode(c.Writer, sse.Event{
+			Id:    state.Cursor.String(),
+			Event: eventVehicleState,
+			Data:  data,
+		})
+		last = state.Cursor
+		return err
+	}
+	if lastEventID != nil {
+		err = forEachVehicleStateCommittedAfter(srv.logger, srv.db, filter, last, 0, write)
+	} else {
+		// sets the id the client reconnects with, without dispatching an event
+		_, err = c.Writer.WriteString("id: " + last.String() + "\n\n")
+	}
+	if err != nil {
+		srv.logger.Printf("Aborted vehicle state stream: %v\n", err)
+		return
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case state, ok := <-sub.states:
+			if !ok {
+				// lagging or shutting down, the client reconnects with Last-Event-ID
+				return
+			}
+			if !last.before(state.Cursor) {
+				// sent from the database already
+				continue
+			}
+			err = write(state)
+		case <-keepAlive.C:
+			extendWriteDeadline(c)
+			_, err = c.Writer.WriteString(": keep-alive\n\n")
+		}
+		if err != nil {
+			srv.logger.Printf("Aborted vehicle state stream: %v\n", err)
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/integrationtest"
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+)
+
+// readEvent reads the fields of the next server-sent event, skipping comments.
+func readEvent(r *bufio.Reader) (map[string]string, error) {
+	event := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(event) > 0 {
+			return event, nil
+		}
+		if line == "" || strings.HasPrefix(line, ":") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		event[parts[0]] = strings.TrimPrefix(parts[1], " ")
+	}
+}
+
+// openStream requests path from server, failing the test unless the stream is opened.
+func openStream(t *testing.T, server *httptest.Server, path string, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	verify.Ok(t, err)
+	verify.Equals(t, http.StatusOK, res.StatusCode)
+	verify.Equals(t, mimeEventStream, res.Header.Get("Content-Type"))
+	return res, bufio.NewReader(res.Body)
+}
+
+func TestStreamVehicleStates(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	server := httptest.NewServer(unit.router)
+	defer server.Close()
+
+	unit.broker.advance(commitCursor{TxID: 1, Index: 1})
+
+	t.Run("Streaming published states", func(t *testing.T) {
+		// arrange
+		res, events := openStream(t, server, "/vehicleStates/stream?vehicleIds=2,3&bbox=19,29,21,31&geometryFormat=wkt", "")
+		defer res.Body.Close()
+		position, err := readEvent(events)
+		verify.Ok(t, err)
+		verify.Equals(t, map[string]string{"id": "1.1"}, position)
+		// action
+		go func() {
+			// wait for subscription
+			for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+				unit.broker.mu.Lock()
+				subscribed = len(unit.broker.subscribers) > 0
+				unit.broker.mu.Unlock()
+			}
+			unit.broker.publish([]vehicleState{
+				brokerTestState(1, 1, orb.Point{20, 30}),
+				brokerTestState(2, 2, orb.Point{25, 30}),
+				brokerTestState(3, 3, orb.Point{20, 30}),
+			}, nil)
+		}()
+		event, err := readEvent(events)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, "3.1", event["id"])
+		verify.Equals(t, eventVehicleState, event["event"])
+		result := struct {
+			VehicleID int64  `json:"vehicleId"`
+			Position  string `json:"position"`
+		}{}
+		verify.Ok(t, json.Unmarshal([]byte(event["data"]), &result))
+		verify.Equals(t, int64(3), result.VehicleID)
+		verify.Equals(t, "POINT(20 30)", result.Position)
+	})
+
+	t.Run("Invalid parameters should return 400", func(t *testing.T) {
+		for _, header := range []string{"", "abc"} {
+			// arrange
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/vehicleStates/stream", nil)
+			if header == "" {
+				req.URL.RawQuery = "vehicleIds=a"
+			} else {
+				req.Header.Set("Last-Event-ID", header)
+			}
+			// action
+			unit.router.ServeHTTP(res, req)
+			// verify
+			verify.Equals(t, http.StatusBadRequest, res.Code)
+		}
+	})
+}
+
+func TestStreamVehicleStatesIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test")
+	}
+
+	// arrange
+	integrationtest.Setup()
+	defer integrationtest.Cleanup()
+	db, _ := integrationtest.GetDbConnectionPool()
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(db, ":5001")
+	unit.CreateDatabaseStructure()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener, err := unit.listen(ctx)
+	verify.Ok(t, err)
+	var last commitCursor
+	go unit.receiveUpdates(ctx, listener, &last)
+	server := httptest.NewServer(unit.router)
+	defer server.Close()
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
+	post := func(lat float64) {
+		body := fmt.Sprintf(`{"vehicleId":%d, "timestamp":"2021-06-15T09:00:00Z", "position":{"type":"Point","coordinates":[20,%v]}}`, vehicleID, lat)
+		res, err := http.Post(server.URL+"/vehicleStates", "application/json", strings.NewReader(body))
+		verify.Ok(t, err)
+		res.Body.Close()
+		verify.Equals(t, http.StatusCreated, res.StatusCode)
+	}
+
+	var lastEventID string
+	t.Run("Streaming stored states", func(t *testing.T) {
+		// arrange
+		res, events := openStream(t, server, fmt.Sprintf("/vehicleStates/stream?vehicleIds=%d", vehicleID), "")
+		defer res.Body.Close()
+		position, err := readEvent(events)
+		verify.Ok(t, err)
+		// action
+		post(30)
+		event, err := readEvent(events)
+		// verify
+		verify.Ok(t, err)
+		verify.Assert(t, position["id"] != "" && position["data"] == "", "unexpected first event %v", position)
+		verify.Assert(t, position["id"] != event["id"], "state has the id of the position")
+		result := vehicleState{}
+		verify.Ok(t, json.Unmarshal([]byte(event["data"]), &result))
+		verify.Equals(t, orb.Point{20, 30}, result.Position.Geometry())
+		lastEventID = event["id"]
+	})
+
+	t.Run("Resuming with Last-Event-ID", func(t *testing.T) {
+		// arrange
+		post(31)
+		post(32)
+		res, events := openStream(t, server, fmt.Sprintf("/vehicleStates/stream?vehicleIds=%d", vehicleID), lastEventID)
+		defer res.Body.Close()
+		// action
+		first, err := readEvent(events)
+		verify.Ok(t, err)
+		second, err := readEvent(events)
+		// verify
+		verify.Ok(t, err)
+		verify.Assert(t, strings.Contains(first["data"], "[20,31]"), "unexpected first event %v", first)
+		verify.Assert(t, strings.Contains(second["data"], "[20,32]"), "unexpected second event %v", second)
+	})
+}
@@ -1,19 +1,63 @@
 package server
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/EricNeid/go-webserver/internal/integrationtest"
 	"github.com/EricNeid/go-webserver/internal/verify"
 	"github.com/gin-gonic/gin"
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
 )
 
+func TestValidateVehicleState(t *testing.T) {
+	// arrange
+	timestamp := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		state vehicleState
+		valid bool
+	}{
+		{"point", vehicleState{Position: *geojson.NewGeometry(orb.Point{20, 30}), Timestamp: timestamp}, true},
+		{"line", vehicleState{Position: *geojson.NewGeometry(orb.LineString{{20, 30}, {21, 30}}), Timestamp: timestamp}, false},
+		{"missing position", vehicleState{Timestamp: timestamp}, false},
+		{"out of range", vehicleState{Position: *geojson.NewGeometry(orb.Point{200, 30}), Timestamp: timestamp}, false},
+		{"not a number", vehicleState{Position: *geojson.NewGeometry(orb.Point{math.NaN(), 30}), Timestamp: timestamp}, false},
+		{"infinite", vehicleState{Position: *geojson.NewGeometry(orb.Point{20, math.Inf(-1)}), Timestamp: timestamp}, false},
+		{"missing timestamp", vehicleState{Position: *geojson.NewGeometry(orb.Point{20, 30})}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// action
+			err := validateVehicleState(test.state)
+			// verify
+			verify.Equals(t, test.valid, err == nil)
+		})
+	}
+}
+
+func TestAddVehicleStateWithInvalidTelemetry(t *testing.T) {
+	// arrange
+	gin.SetMode(gin.TestMode)
+	unit := NewApplicationServer(nil, ":5001")
+	testdata := `{"vehicleId": 1, "timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}, "heading": 360}`
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/vehicleStates", strings.NewReader(testdata))
+	// action
+	unit.router.ServeHTTP(res, req)
+	// verify
+	verify.Equals(t, http.StatusBadRequest, res.Code)
+}
+
 func TestCrudVehicleStateIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test")
@@ -25,14 +69,18 @@ func TestCrudVehicleStateIntegration(t *testing.T) {
 	db, _ := integrationtest.GetDbConnectionPool()
 	gin.SetMode(gin.TestMode)
 	unit := NewApplicationServer(db, ":5001")
-	createTableVehicleState(unit.logger, unit.db)
+	unit.CreateDatabaseStructure()
+	vehicleID, _ := addVehicle(unit.logger, unit.db, vehicle{Name: "truck"})
 
 	var id int64
 	t.Run("Add", func(t *testing.T) {
 		// arrange
-		testdata := `
+		testdata := fmt.Sprintf(`
 		{
+			"vehicleId": %d,
 			"timestamp": "2021-06-15T09:00:00Z",
+			"speed": 12.5,
+			"attributes": {"door": "closed"},
 			"position": {
 				"type": "Point",
 				"coordinates": [
@@ -41,7 +89,7 @@ func TestCrudVehicleStateIntegration(t *testing.T) {
 				]
 			}
 		}
-		`
+		`, vehicleID)
 		res := httptest.NewRecorder()
 		req := httptest.NewRequest("POST", "/vehicleStates", strings.NewReader(testdata))
 		// action
@@ -56,6 +104,17 @@ func TestCrudVehicleStateIntegration(t *testing.T) {
 		id = result.VehicleStateId
 	})
 
+	t.Run("Add without vehicle should return 400", func(t *testing.T) {
+		// arrange
+		testdata := `{"timestamp": "2021-06-15T09:00:00Z", "position": {"type": "Point", "coordinates": [20, 30]}}`
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/vehicleStates", strings.NewReader(testdata))
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
 	t.Run("Get by id", func(t *testing.T) {
 		// arrange
 		res := httptest.NewRecorder()
@@ -78,6 +137,34 @@ func TestCrudVehicleStateIntegration(t *testing.T) {
 		verify.Condition(t, result.VehicleState.Timestamp.Hour() == 9)
 	})
 
+	t.Run("Add and get with WKT position", func(t *testing.T) {
+		// arrange
+		testdata := fmt.Sprintf(`{"vehicleId": %d, "timestamp": "2021-06-15T08:00:00Z", "position": "POINT(20 30)"}`, vehicleID)
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/vehicleStates", strings.NewReader(testdata))
+		unit.router.ServeHTTP(res, req)
+		verify.Equals(t, http.StatusCreated, res.Code)
+		created := struct {
+			VehicleStateId int64 `json:"vehicleStateId"`
+		}{}
+		verify.Ok(t, json.NewDecoder(res.Body).Decode(&created))
+		defer deleteVehicleState(unit.logger, unit.db, created.VehicleStateId)
+		res = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", fmt.Sprintf("/vehicleStates/%d?geometryFormat=wkt", created.VehicleStateId), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleState struct {
+				Position string `json:"position"`
+			} `json:"vehicleState"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, "POINT(20 30)", result.VehicleState.Position)
+	})
+
 	t.Run("Get all", func(t *testing.T) {
 		// arrange
 		res := httptest.NewRecorder()
@@ -94,6 +181,137 @@ func TestCrudVehicleStateIntegration(t *testing.T) {
 		verify.Equals(t, 1, len(result.VehicleStates))
 	})
 
+	t.Run("Get all as GeoJSON", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates", nil)
+		req.Header.Set("Accept", "application/geo+json")
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		verify.Equals(t, "application/geo+json", res.Header().Get("Content-Type"))
+		result, err := geojson.UnmarshalFeatureCollection(res.Body.Bytes())
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.Features))
+		verify.Equals(t, orb.Point{20, 30}, result.Features[0].Geometry)
+		verify.Equals(t, "2021-06-15T09:00:00Z", result.Features[0].Properties["timestamp"])
+		verify.Equals(t, orb.Bound{Min: orb.Point{20, 30}, Max: orb.Point{20, 30}}, result.BBox.Bound())
+	})
+
+	t.Run("Get all as CSV", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates?tz=Europe/Berlin", nil)
+		req.Header.Set("Accept", "text/csv")
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		records, err := csv.NewReader(res.Body).ReadAll()
+		verify.Ok(t, err)
+		verify.Equals(t, 2, len(records))
+		verify.Equals(t, vehicleStateCSVHeader, records[0])
+		verify.Equals(t, []string{"20", "30", "2021-06-15T11:00:00+02:00"}, records[1][2:5])
+	})
+
+	t.Run("Get all with unknown format should return 400", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates?format=xml", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Get all inside bbox", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates?bbox=25,25,30,35", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleStates []vehicleState `json:"vehicleStates"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 0, len(result.VehicleStates))
+	})
+
+	t.Run("Get all inside bbox and time range", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates?bbox=19,29,21,31&from=2021-06-15T09:00:00Z&to=2021-06-15T10:00:00Z&order=desc", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleStates []vehicleState `json:"vehicleStates"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.VehicleStates))
+	})
+
+	t.Run("Get all with attributes", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates?attributes="+url.QueryEscape(`{"door":"closed"}`), nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleStates []vehicleState `json:"vehicleStates"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.VehicleStates))
+		verify.Equals(t, 12.5, *result.VehicleStates[0].Speed)
+	})
+
+	t.Run("Get all with invalid bbox should return 400", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates?bbox=1,2,3", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Get nearest", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates/near?lon=20&lat=30.001&k=5", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusOK, res.Code)
+		result := struct {
+			VehicleStates []nearbyVehicleState `json:"vehicleStates"`
+		}{}
+		err := json.NewDecoder(res.Body).Decode(&result)
+		verify.Ok(t, err)
+		verify.Equals(t, 1, len(result.VehicleStates))
+		verify.Equals(t, id, result.VehicleStates[0].ID)
+		verify.Condition(t, result.VehicleStates[0].Distance > 100)
+	})
+
+	t.Run("Get nearest without radius or k should return 400", func(t *testing.T) {
+		// arrange
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/vehicleStates/near?lon=20&lat=30", nil)
+		// action
+		unit.router.ServeHTTP(res, req)
+		// verify
+		verify.Equals(t, http.StatusBadRequest, res.Code)
+	})
+
 	t.Run("Delete by id", func(t *testing.T) {
 		// arrange
 		res := httptest.NewRecorder()
@@ -18,11 +18,33 @@ type ApplicationServer struct {
 	db        *pgxpool.Pool
 	webserver *http.Server
 	router    *gin.Engine
+	broker    *broker
+	replays   *replayRegistry
+
+	tripConfig     TripConfig
+	distanceConfig DistanceConfig
+}
+
+// Option configures an ApplicationServer.
+type Option func(*ApplicationServer)
+
+// WithTripConfig sets the thresholds used for trip and stop detection.
+func WithTripConfig(config TripConfig) Option {
+	return func(srv *ApplicationServer) {
+		srv.tripConfig = config
+	}
+}
+
+// WithDistanceConfig sets the GPS jitter filter used when computing distances travelled.
+func WithDistanceConfig(config DistanceConfig) Option {
+	return func(srv *ApplicationServer) {
+		srv.distanceConfig = config
+	}
 }
 
 // NewApplicationServer creates a new server with the given configuration.
 // listenAddr example: ":5000"
-func NewApplicationServer(db *pgxpool.Pool, listenAddr string) ApplicationServer {
+func NewApplicationServer(db *pgxpool.Pool, listenAddr string, options ...Option) ApplicationServer {
 	// create logger
 	logger := log.New(os.Stdout, "server", log.LstdFlags)
 
@@ -31,9 +53,11 @@ func NewApplicationServer(db *pgxpool.Pool, listenAddr string) ApplicationServer
 
 	// create application server
 	server := ApplicationServer{
-		logger: logger,
-		router: router,
-		db:     db,
+		logger:  logger,
+		router:  router,
+		db:      db,
+		broker:  newBroker(),
+		replays: newReplayRegistry(),
 		webserver: &http.Server{
 			Addr:         listenAddr,
 			Handler:      router,
@@ -41,8 +65,18 @@ func NewApplicationServer(db *pgxpool.Pool, listenAddr string) ApplicationServer
 			ReadTimeout:  5 * time.Second,
 			WriteTimeout: 10 * time.Second,
 			IdleTimeout:  15 * time.Second,
+			// streamed requests replace the timeouts, see setReadDeadline
+			ConnContext: withConn,
 		},
+		tripConfig:     DefaultTripConfig,
+		distanceConfig: DefaultDistanceConfig,
+	}
+	for _, option := range options {
+		option(&server)
 	}
+	// end live streams, they would delay the shutdown otherwise
+	server.webserver.RegisterOnShutdown(server.broker.close)
+	server.webserver.RegisterOnShutdown(server.replays.close)
 
 	// configure routes
 	router.GET("/", welcome)
@@ -53,11 +87,50 @@ func NewApplicationServer(db *pgxpool.Pool, listenAddr string) ApplicationServer
 	router.DELETE("/users/:id", server.deleteUser)
 	router.POST("/users", server.addUser)
 
+	// vehicle crud
+	router.GET("/vehicles", server.getVehicles)
+	router.GET("/vehicles/positions/latest", server.getLatestVehicleStates)
+	router.GET("/vehicles/distance", server.getDistances)
+	router.GET("/vehicles/:id", server.getVehicle)
+	router.PUT("/vehicles/:id", server.updateVehicle)
+	router.DELETE("/vehicles/:id", server.deleteVehicle)
+	router.POST("/vehicles", server.addVehicle)
+	router.GET("/vehicles/:id/states", server.getVehicleStatesOfVehicle)
+	router.GET("/vehicles/:id/trajectory", server.getTrajectory)
+	router.GET("/vehicles/:id/trips", server.getTrips)
+	router.GET("/vehicles/:id/distance", server.getDistanceOfVehicle)
+	router.GET("/vehicles/:id/track.gpx", server.getTrackGPX)
+	router.POST("/vehicles/:id/track", server.addTrack)
+	router.GET("/vehicles/:id/events", server.getGeofenceEventsOfVehicle)
+	router.GET("/vehicles/:id/replay", server.replayVehicleStates)
+	router.GET("/replays/:id", server.getReplay)
+	router.PUT("/replays/:id", server.updateReplay)
+
 	// vehicle state crud
 	router.GET("/vehicleStates", server.getVehicleStates)
+	router.GET("/vehicleStates/near", server.getNearbyVehicleStates)
+	router.GET("/vehicleStates/aggregate", server.getVehicleStateAggregate)
+	router.GET("/vehicleStates/stream", server.streamVehicleStates)
 	router.GET("/vehicleStates/:id", server.getVehicleState)
 	router.DELETE("/vehicleStates/:id", server.deleteVehicleState)
 	router.POST("/vehicleStates", server.addVehicleState)
+	// action matches the whole rest of the segment, see vehicleStatesAction
+	router.POST("/vehicleStates:action", server.vehicleStatesAction)
+
+	// vector tiles
+	router.GET("/tiles/vehicleStates/:z/:x/:y", server.getVehicleStateTile)
+
+	// live updates
+	router.GET("/live", server.live)
+
+	// geofence crud
+	router.GET("/geofences", server.getGeofences)
+	router.GET("/geofences/contains", server.getGeofencesContaining)
+	router.GET("/geofences/:id", server.getGeofence)
+	router.GET("/geofences/:id/events", server.getGeofenceEventsOfGeofence)
+	router.PUT("/geofences/:id", server.updateGeofence)
+	router.DELETE("/geofences/:id", server.deleteGeofence)
+	router.POST("/geofences", server.addGeofence)
 
 	return server
 }
@@ -66,7 +139,31 @@ func NewApplicationServer(db *pgxpool.Pool, listenAddr string) ApplicationServer
 func (srv ApplicationServer) CreateDatabaseStructure() error {
 	logger := srv.logger
 	db := srv.db
-	err := createTableVehicleState(logger, db)
+	err := createTableVehicle(logger, db)
+	if err != nil {
+		return err
+	}
+	err = createTableVehicleState(logger, db)
+	if err != nil {
+		return err
+	}
+	err = createTableVehicleStateCommit(logger, db)
+	if err != nil {
+		return err
+	}
+	err = createTableGeofence(logger, db)
+	if err != nil {
+		return err
+	}
+	err = createTableGeofenceEvent(logger, db)
+	if err != nil {
+		return err
+	}
+	err = createTableTrip(logger, db)
+	if err != nil {
+		return err
+	}
+	err = createTableReplaySession(logger, db)
 	if err != nil {
 		return err
 	}
@@ -94,6 +191,14 @@ func (srv ApplicationServer) GracefullShutdown(quit <-chan os.Signal, done chan<
 }
 
 // ListenAndServe starts listening for requests.
+// Vehicle states stored by any instance sharing the database are published to live subscribers while serving, see listenForUpdates.
+// Trips are updated in the background, see maintainTrips.
 func (srv ApplicationServer) ListenAndServe() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if srv.db != nil {
+		go srv.listenForUpdates(ctx)
+		go srv.maintainTrips(ctx)
+	}
 	return srv.webserver.ListenAndServe()
 }
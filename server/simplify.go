@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+)
+
+// headerDroppedPoints reports the number of states removed by simplification.
+const headerDroppedPoints = "X-Dropped-Points"
+
+// simplifyOptions select how the states of each vehicle are thinned out, see simplifyStates.
+type simplifyOptions struct {
+	// Tolerance in metres for Douglas-Peucker simplification, 0 disables it.
+	Tolerance float64
+	// MaxPoints per vehicle for LTTB downsampling, 0 disables it.
+	MaxPoints int
+}
+
+// simplifyOptionsFromQuery reads the optional query parameters simplify (tolerance in metres)
+// and maxPoints (number of states to keep per vehicle, at least 2).
+func simplifyOptionsFromQuery(c *gin.Context) (simplifyOptions, error) {
+	var opts simplifyOptions
+	if value := c.Query("simplify"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance <= 0 {
+			return opts, errors.New("simplify must be a positive tolerance in metres")
+		}
+		opts.Tolerance = tolerance
+	}
+	if value := c.Query("maxPoints"); value != "" {
+		maxPoints, err := strconv.Atoi(value)
+		if err != nil || maxPoints < 2 {
+			return opts, errors.New("maxPoints must be an integer of at least 2")
+		}
+		opts.MaxPoints = maxPoints
+	}
+	return opts, nil
+}
+
+func (opts simplifyOptions) enabled() bool {
+	return opts.Tolerance > 0 || opts.MaxPoints > 0
+}
+
+// simplifyStates thins out the states of each vehicle, first by Douglas-Peucker with the given tolerance,
+// then by largest triangle three buckets downsampling to MaxPoints. The first and last state of each vehicle
+// and states where ignition or attributes change are always kept, even if more than MaxPoints.
+// The remaining states are returned in their original order, together with the number of states dropped.
+func simplifyStates(states []vehicleState, opts simplifyOptions) ([]vehicleState, int) {
+	if !opts.enabled() {
+		return states, 0
+	}
+	tracks := map[int64][]int{}
+	for i, state := range states {
+		tracks[state.VehicleID] = append(tracks[state.VehicleID], i)
+	}
+	keep := make([]bool, len(states))
+	for _, indices := range tracks {
+		track := make([]vehicleState, len(indices))
+		for i, index := range indices {
+			track[i] = states[index]
+		}
+		for i, kept := range simplifyTrack(track, opts) {
+			keep[indices[i]] = kept
+		}
+	}
+
+	result := make([]vehicleState, 0, len(states))
+	for i, state := range states {
+		if keep[i] {
+			result = append(result, state)
+		}
+	}
+	return result, len(states) - len(result)
+}
+
+// simplifyTrack reports which of the ordered states of a single vehicle to keep.
+func simplifyTrack(track []vehicleState, opts simplifyOptions) []bool {
+	keep := make([]bool, len(track))
+	if len(track) <= 2 {
+		for i := range keep {
+			keep[i] = true
+		}
+		return keep
+	}
+	points := projectTrack(track)
+
+	anchors := []int{0}
+	for i := 1; i < len(track)-1; i++ {
+		if attributesChanged(track[i-1], track[i]) {
+			anchors = append(anchors, i)
+		}
+	}
+	anchors = append(anchors, len(track)-1)
+
+	if opts.Tolerance > 0 {
+		for _, anchor := range anchors {
+			keep[anchor] = true
+		}
+		for i := 1; i < len(anchors); i++ {
+			douglasPeucker(points, anchors[i-1], anchors[i], opts.Tolerance, keep)
+		}
+	} else {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+
+	if opts.MaxPoints > 0 {
+		var candidates []int
+		for i, kept := range keep {
+			if kept {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) > opts.MaxPoints {
+			keep = make([]bool, len(track))
+			for _, anchor := range anchors {
+				keep[anchor] = true
+			}
+			// interior anchors take up part of the budget
+			for _, selected := range largestTriangleThreeBuckets(points, candidates, opts.MaxPoints-len(anchors)+2) {
+				keep[selected] = true
+			}
+		}
+	}
+	return keep
+}
+
+// attributesChanged reports whether ignition or attributes differ between consecutive states.
+func attributesChanged(prev, next vehicleState) bool {
+	if prev.Ignition != nil && next.Ignition != nil && *prev.Ignition != *next.Ignition {
+		return true
+	}
+	if len(prev.Attributes) == 0 && len(next.Attributes) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(prev.Attributes, next.Attributes)
+}
+
+// projectTrack projects the positions of the track onto a plane in metres,
+// using an equirectangular projection around the first position.
+func projectTrack(track []vehicleState) []orb.Point {
+	points := make([]orb.Point, len(track))
+	origin, _ := track[0].Position.Geometry().(orb.Point)
+	scale := math.Pi / 180 * orb.EarthRadius
+	cosLat := math.Cos(origin.Lat() * math.Pi / 180)
+	for i, state := range track {
+		p, _ := state.Position.Geometry().(orb.Point)
+		dLon := math.Mod(p.Lon()-origin.Lon()+540, 360) - 180
+		points[i] = orb.Point{dLon * cosLat * scale, (p.Lat() - origin.Lat()) * scale}
+	}
+	return points
+}
+
+// douglasPeucker marks the points between first and last to keep, so that the dropped points
+// are within tolerance of the simplified line.
+func douglasPeucker(points []orb.Point, first, last int, tolerance float64, keep []bool) {
+	stack := [][2]int{{first, last}}
+	for len(stack) > 0 {
+		segment := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDistance, index := 0.0, -1
+		for i := segment[0] + 1; i < segment[1]; i++ {
+			if d := segmentDistance(points[i], points[segment[0]], points[segment[1]]); d > maxDistance {
+				maxDistance, index = d, i
+			}
+		}
+		if index >= 0 && maxDistance > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{segment[0], index}, [2]int{index, segment[1]})
+		}
+	}
+}
+
+// segmentDistance returns the distance of p to the segment from a to b.
+func segmentDistance(p, a, b orb.Point) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/length))
+	}
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
+
+// largestTriangleThreeBuckets selects threshold of the given candidate indices, keeping the first and last.
+// The candidates in between are split into buckets, from each the point spanning the largest triangle
+// with the previously selected point and the average of the next bucket is selected.
+func largestTriangleThreeBuckets(points []orb.Point, candidates []int, threshold int) []int {
+	if threshold >= len(candidates) {
+		return candidates
+	}
+	selected := []int{candidates[0], candidates[len(candidates)-1]}
+	if threshold < 3 {
+		return selected
+	}
+	selected = selected[:1]
+	every := float64(len(candidates)-2) / float64(threshold-2)
+	a := points[candidates[0]]
+	for i := 0; i < threshold-2; i++ {
+		// average of the next bucket
+		avgStart := int(float64(i+1)*every) + 1
+		avgEnd := int(float64(i+2)*every) + 1
+		if avgEnd > len(candidates) {
+			avgEnd = len(candidates)
+		}
+		var avg orb.Point
+		for _, index := range candidates[avgStart:avgEnd] {
+			avg[0] += points[index][0]
+			avg[1] += points[index][1]
+		}
+		avg[0] /= float64(avgEnd - avgStart)
+		avg[1] /= float64(avgEnd - avgStart)
+
+		// point of the current bucket spanning the largest triangle
+		maxArea, next := -1.0, 0
+		for _, index := range candidates[int(float64(i)*every)+1 : int(float64(i+1)*every)+1] {
+			p := points[index]
+			area := math.Abs((a[0]-avg[0])*(p[1]-a[1]) - (a[0]-p[0])*(avg[1]-a[1]))
+			if area > maxArea {
+				maxArea, next = area, index
+			}
+		}
+		selected = append(selected, next)
+		a = points[next]
+	}
+	return append(selected, candidates[len(candidates)-1])
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// simplifyTestStates returns states of a vehicle driving east along a straight line,
+// with a peak of 1 km to the north in the middle.
+func simplifyTestStates(vehicleID int64, n int) []vehicleState {
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	states := make([]vehicleState, n)
+	for i := range states {
+		lat := 52.0
+		if i == n/2 {
+			lat = 52.009
+		}
+		states[i] = vehicleState{
+			VehicleID: vehicleID,
+			Position:  *geojson.NewGeometry(orb.Point{13.0 + float64(i)*0.001, lat}),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return states
+}
+
+func TestSimplifyStates(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		// arrange
+		states := simplifyTestStates(1, 10)
+		// action
+		result, dropped := simplifyStates(states, simplifyOptions{})
+		// verify
+		verify.Equals(t, 10, len(result))
+		verify.Equals(t, 0, dropped)
+	})
+
+	t.Run("douglas peucker", func(t *testing.T) {
+		// arrange
+		states := simplifyTestStates(1, 11)
+		// action
+		result, dropped := simplifyStates(states, simplifyOptions{Tolerance: 10})
+		// verify
+		verify.Equals(t, 6, dropped)
+		verify.Equals(t, []vehicleState{states[0], states[4], states[5], states[6], states[10]}, result)
+	})
+
+	t.Run("attribute changes are kept", func(t *testing.T) {
+		// arrange
+		states := simplifyTestStates(1, 11)
+		on, off := true, false
+		for i := range states {
+			states[i].Ignition = &on
+		}
+		states[3].Ignition = &off
+		states[7].Attributes = map[string]interface{}{"door": "open"}
+		// action
+		result, dropped := simplifyStates(states, simplifyOptions{Tolerance: 10})
+		// verify
+		verify.Equals(t, 3, dropped) // ignition off at 3 and on at 4, attributes at 7 and 8
+		verify.Equals(t, []vehicleState{states[0], states[3], states[4], states[5], states[6], states[7], states[8], states[10]}, result)
+	})
+
+	t.Run("max points", func(t *testing.T) {
+		// arrange
+		states := simplifyTestStates(1, 101)
+		// action
+		result, dropped := simplifyStates(states, simplifyOptions{MaxPoints: 10})
+		// verify
+		verify.Equals(t, 10, len(result))
+		verify.Equals(t, 91, dropped)
+		verify.Equals(t, states[0], result[0])
+		verify.Equals(t, states[100], result[9])
+		verify.Assert(t, containsState(result, states[50]), "peak is missing")
+	})
+
+	t.Run("each vehicle in original order", func(t *testing.T) {
+		// arrange
+		first, second := simplifyTestStates(1, 11), simplifyTestStates(2, 11)
+		var states []vehicleState
+		for i := len(first) - 1; i >= 0; i-- {
+			states = append(states, first[i], second[i])
+		}
+		// action
+		result, dropped := simplifyStates(states, simplifyOptions{Tolerance: 10})
+		// verify
+		verify.Equals(t, 12, dropped)
+		verify.Equals(t, []vehicleState{
+			first[10], second[10], first[6], second[6], first[5], second[5], first[4], second[4], first[0], second[0],
+		}, result)
+	})
+}
+
+func containsState(states []vehicleState, state vehicleState) bool {
+	for _, s := range states {
+		if s.Timestamp.Equal(state.Timestamp) && s.VehicleID == state.VehicleID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSegmentDistance(t *testing.T) {
+	verify.Equals(t, 1.0, segmentDistance(orb.Point{1, 1}, orb.Point{0, 0}, orb.Point{2, 0}))
+	verify.Equals(t, 5.0, segmentDistance(orb.Point{5, 4}, orb.Point{0, 0}, orb.Point{2, 0}))
+	verify.Equals(t, 5.0, segmentDistance(orb.Point{3, 4}, orb.Point{0, 0}, orb.Point{0, 0}))
+}
+
+func TestSimplifyOptionsFromQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for query, valid := range map[string]bool{
+		"":                        true,
+		"simplify=5&maxPoints=10": true,
+		"simplify=0":              false,
+		"maxPoints=1":             false,
+		"maxPoints=ten":           false,
+	} {
+		t.Run(query, func(t *testing.T) {
+			// arrange
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/vehicleStates?"+query, nil)
+			// action
+			_, err := simplifyOptionsFromQuery(c)
+			// verify
+			verify.Equals(t, valid, err == nil)
+		})
+	}
+}
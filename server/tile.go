@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/paulmach/orb"
+)
+
+// maxTileZoom is the highest zoom level tiles are served for.
+const maxTileZoom = 24
+
+// webMercatorMax is the extent of the web mercator projection (EPSG:3857) in metres.
+const webMercatorMax = 20037508.342789244
+
+// tile addresses a map tile in the XYZ scheme used by web maps, y growing southwards.
+type tile struct {
+	Z, X, Y uint32
+}
+
+// parseTile reads the zoom level and column/row of a tile, checking that the tile exists.
+func parseTile(z, x, y string) (tile, error) {
+	zoom, err := strconv.ParseUint(z, 10, 32)
+	if err != nil || zoom > maxTileZoom {
+		return tile{}, errors.New("z must be a zoom level between 0 and 24")
+	}
+	n := uint64(1) << zoom
+	column, err := strconv.ParseUint(x, 10, 32)
+	if err != nil || column >= n {
+		return tile{}, errors.New("x must be a column between 0 and 2^z-1")
+	}
+	row, err := strconv.ParseUint(y, 10, 32)
+	if err != nil || row >= n {
+		return tile{}, errors.New("y must be a row between 0 and 2^z-1")
+	}
+	return tile{Z: uint32(zoom), X: uint32(column), Y: uint32(row)}, nil
+}
+
+// mercatorBound returns the extent of the tile in web mercator metres.
+func (t tile) mercatorBound() orb.Bound {
+	size := 2 * webMercatorMax / float64(uint64(1)<<t.Z)
+	minX := -webMercatorMax + float64(t.X)*size
+	maxY := webMercatorMax - float64(t.Y)*size
+	return orb.Bound{Min: orb.Point{minX, maxY - size}, Max: orb.Point{minX + size, maxY}}
+}
+
+// bound returns the extent of the tile in lon/lat.
+func (t tile) bound() orb.Bound {
+	n := float64(uint64(1) << t.Z)
+	lon := func(x float64) float64 {
+		return x/n*360 - 180
+	}
+	lat := func(y float64) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+	}
+	return orb.Bound{
+		Min: orb.Point{lon(float64(t.X)), lat(float64(t.Y) + 1)},
+		Max: orb.Point{lon(float64(t.X) + 1), lat(float64(t.Y))},
+	}
+}
+
+// lonLatToMercator converts a lon/lat position to web mercator metres.
+func lonLatToMercator(p orb.Point) orb.Point {
+	return orb.Point{
+		p.Lon() / 180 * webMercatorMax,
+		math.Log(math.Tan((90+p.Lat())*math.Pi/360)) / math.Pi * webMercatorMax,
+	}
+}
+
+// mercatorToLonLat converts a position in web mercator metres to lon/lat.
+func mercatorToLonLat(p orb.Point) orb.Point {
+	return orb.Point{
+		p.X() / webMercatorMax * 180,
+		math.Atan(math.Sinh(p.Y()/webMercatorMax*math.Pi)) * 180 / math.Pi,
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+)
+
+func TestParseTile(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		// action
+		result, err := parseTile("10", "568", "422")
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, tile{Z: 10, X: 568, Y: 422}, result)
+	})
+
+	for _, test := range [][3]string{{"25", "0", "0"}, {"1", "2", "0"}, {"1", "0", "2"}, {"-1", "0", "0"}, {"a", "0", "0"}, {"0", "0", "b"}} {
+		t.Run("invalid", func(t *testing.T) {
+			// action
+			_, err := parseTile(test[0], test[1], test[2])
+			// verify
+			verify.Assert(t, err != nil, "expected error for %v", test)
+		})
+	}
+}
+
+func TestTileBound(t *testing.T) {
+	t.Run("world", func(t *testing.T) {
+		// action
+		result := tile{}.bound()
+		mercator := tile{}.mercatorBound()
+		// verify
+		verify.Equals(t, -180.0, result.Min.Lon())
+		verify.Equals(t, 180.0, result.Max.Lon())
+		verify.Condition(t, math.Abs(result.Max.Lat()-85.0511) < 0.0001)
+		verify.Equals(t, orb.Bound{Min: orb.Point{-webMercatorMax, -webMercatorMax}, Max: orb.Point{webMercatorMax, webMercatorMax}}, mercator)
+	})
+
+	t.Run("contains position", func(t *testing.T) {
+		for _, unit := range []tile{{Z: 10, X: 568, Y: 422}, {Z: 16, X: 36408, Y: 27038}} {
+			// action
+			result := unit.bound()
+			mercator := unit.mercatorBound()
+			// verify
+			verify.Assert(t, result.Contains(orb.Point{20, 30}), "%v does not contain position", unit)
+			verify.Condition(t, math.Abs(mercator.Max.X()-mercator.Min.X()-2*webMercatorMax/math.Pow(2, float64(unit.Z))) < 0.001)
+		}
+	})
+}
+
+func TestMercatorToLonLat(t *testing.T) {
+	// arrange
+	unit := tile{Z: 10, X: 568, Y: 422}
+	// action
+	min := mercatorToLonLat(unit.mercatorBound().Min)
+	max := mercatorToLonLat(unit.mercatorBound().Max)
+	// verify
+	bound := unit.bound()
+	verify.Condition(t, math.Abs(min.Lon()-bound.Min.Lon()) < 1e-9)
+	verify.Condition(t, math.Abs(min.Lat()-bound.Min.Lat()) < 1e-9)
+	verify.Condition(t, math.Abs(max.Lon()-bound.Max.Lon()) < 1e-9)
+	verify.Condition(t, math.Abs(max.Lat()-bound.Max.Lat()) < 1e-9)
+}
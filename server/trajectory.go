@@ -0,0 +1,48 @@
+package server
+
+import (
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/geojson"
+)
+
+// newTrajectory assembles the given time ordered states of a vehicle into a single feature.
+// The geometry is a LineString, or a Point if only one state is given, or null if states is empty.
+// The timestamps of the points are stored in the parallel property "timestamps",
+// the total distance (metres) and duration (seconds) in "distance" and "duration".
+func newTrajectory(vehicleID int64, states []vehicleState) *geojson.Feature {
+	line := make(orb.LineString, 0, len(states))
+	timestamps := make([]time.Time, 0, len(states))
+	distance := 0.0
+	for i, state := range states {
+		point := state.Position.Geometry().(orb.Point)
+		if i > 0 {
+			distance += geo.DistanceHaversine(line[i-1], point)
+		}
+		line = append(line, point)
+		timestamps = append(timestamps, state.Timestamp)
+	}
+
+	var feature *geojson.Feature
+	switch len(line) {
+	case 0:
+		feature = geojson.NewFeature(nil)
+	case 1:
+		feature = geojson.NewFeature(line[0])
+	default:
+		feature = geojson.NewFeature(line)
+	}
+	feature.Properties["vehicleId"] = vehicleID
+	feature.Properties["timestamps"] = timestamps
+	feature.Properties["distance"] = distance
+	feature.Properties["duration"] = 0.0
+	if len(timestamps) > 0 {
+		start, end := timestamps[0], timestamps[len(timestamps)-1]
+		feature.Properties["startTime"] = start
+		feature.Properties["endTime"] = end
+		feature.Properties["duration"] = end.Sub(start).Seconds()
+	}
+	return feature
+}
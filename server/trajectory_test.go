@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestNewTrajectory(t *testing.T) {
+	t.Run("multiple states", func(t *testing.T) {
+		// arrange
+		start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+		states := []vehicleState{
+			{VehicleID: 1, Position: *geojson.NewGeometry(orb.Point{13.0, 52.0}), Timestamp: start},
+			{VehicleID: 1, Position: *geojson.NewGeometry(orb.Point{13.0, 52.1}), Timestamp: start.Add(10 * time.Minute)},
+			{VehicleID: 1, Position: *geojson.NewGeometry(orb.Point{13.0, 52.2}), Timestamp: start.Add(20 * time.Minute)},
+		}
+		// action
+		result := newTrajectory(1, states)
+		// verify
+		verify.Equals(t, orb.LineString{{13.0, 52.0}, {13.0, 52.1}, {13.0, 52.2}}, result.Geometry)
+		verify.Equals(t, int64(1), result.Properties["vehicleId"])
+		verify.Equals(t, 3, len(result.Properties["timestamps"].([]time.Time)))
+		verify.Equals(t, 1200.0, result.Properties["duration"])
+		distance := result.Properties["distance"].(float64)
+		verify.Assert(t, distance > 22200 && distance < 22300, "unexpected distance %v", distance)
+	})
+
+	t.Run("single state", func(t *testing.T) {
+		// arrange
+		states := []vehicleState{
+			{VehicleID: 1, Position: *geojson.NewGeometry(orb.Point{13.0, 52.0}), Timestamp: time.Now()},
+		}
+		// action
+		result := newTrajectory(1, states)
+		// verify
+		verify.Equals(t, orb.Point{13.0, 52.0}, result.Geometry)
+		verify.Equals(t, 0.0, result.Properties["distance"])
+		verify.Equals(t, 0.0, result.Properties["duration"])
+	})
+
+	t.Run("no states", func(t *testing.T) {
+		// action
+		result := newTrajectory(1, nil)
+		data, err := json.Marshal(result)
+		// verify
+		verify.Ok(t, err)
+		verify.Equals(t, `{"type":"Feature","geometry":null,"properties":{"distance":0,"duration":0,"timestamps":[],"vehicleId":1}}`, string(data))
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/geojson"
+)
+
+// Types of trip segments.
+const (
+	tripTypeTrip = "trip"
+	tripTypeStop = "stop"
+)
+
+// TripConfig holds the thresholds used to split the states of a vehicle into trips and stops.
+type TripConfig struct {
+	// StopSpeed in m/s. States reporting a higher speed are moving, states without speed may be stopped.
+	StopSpeed float64
+	// StopRadius in metres a vehicle has to stay within to be stopped.
+	StopRadius float64
+	// MinStopDuration a vehicle has to stay within StopRadius for a stop.
+	MinStopDuration time.Duration
+	// MinTripDistance in metres. Shorter movements between stops are considered part of the stop.
+	MinTripDistance float64
+	// UpdateInterval at which trips are updated with new states in the background, see maintainTrips.
+	// Zero disables the updates.
+	UpdateInterval time.Duration
+}
+
+// DefaultTripConfig is used unless configured otherwise, see WithTripConfig.
+var DefaultTripConfig = TripConfig{
+	StopSpeed:       1,
+	StopRadius:      50,
+	MinStopDuration: 5 * time.Minute,
+	MinTripDistance: 100,
+	UpdateInterval:  time.Minute,
+}
+
+// segmentTrips splits the time ordered states of a vehicle into alternating trips and stops.
+// A stop begins with a state that is not moving, see TripConfig.StopSpeed, and lasts as long as
+// the following states stay within TripConfig.StopRadius of it, at least TripConfig.MinStopDuration.
+// The movements between stops become trips, starting at the last state of the preceding stop and
+// ending at the first state of the next one. The last segment may still be ongoing.
+func segmentTrips(vehicleID int64, states []vehicleState, config TripConfig) []trip {
+	positions := make([]orb.Point, len(states))
+	for i, state := range states {
+		positions[i], _ = state.Position.Geometry().(orb.Point)
+	}
+	stopped := func(state vehicleState) bool {
+		return state.Speed == nil || *state.Speed <= config.StopSpeed
+	}
+
+	var segments []trip
+	stopStart := 0 // index of the first state of the last segment, if it is a stop
+	// addStop appends the stop from states[start] to states[end], merging it into a directly preceding stop.
+	addStop := func(start, end int) {
+		if n := len(segments); n > 0 && segments[n-1].Type == tripTypeStop {
+			segments[n-1] = newStop(vehicleID, states[stopStart:end+1], positions[stopStart:end+1])
+			return
+		}
+		stopStart = start
+		segments = append(segments, newStop(vehicleID, states[start:end+1], positions[start:end+1]))
+	}
+	moveStart := 0
+	for i := 0; i < len(states); i++ {
+		if !stopped(states[i]) {
+			continue
+		}
+		j := i
+		for j+1 < len(states) && stopped(states[j+1]) && geo.DistanceHaversine(positions[i], positions[j+1]) <= config.StopRadius {
+			j++
+		}
+		if states[j].Timestamp.Sub(states[i].Timestamp) < config.MinStopDuration {
+			continue
+		}
+		movement := newTrip(vehicleID, states[moveStart:i+1], positions[moveStart:i+1])
+		if i > moveStart && movement.Distance >= config.MinTripDistance {
+			segments = append(segments, movement)
+			addStop(i, j)
+		} else {
+			addStop(moveStart, j)
+		}
+		moveStart = j
+		i = j
+	}
+	if moveStart < len(states)-1 {
+		movement := newTrip(vehicleID, states[moveStart:], positions[moveStart:])
+		if movement.Distance >= config.MinTripDistance {
+			segments = append(segments, movement)
+		} else if len(segments) > 0 {
+			addStop(moveStart, len(states)-1)
+		}
+	}
+	return segments
+}
+
+// newTrip creates a trip along the given states.
+func newTrip(vehicleID int64, states []vehicleState, positions []orb.Point) trip {
+	distance := 0.0
+	for i := 1; i < len(positions); i++ {
+		distance += geo.DistanceHaversine(positions[i-1], positions[i])
+	}
+	start, end := states[0], states[len(states)-1]
+	return trip{
+		VehicleID:     vehicleID,
+		Type:          tripTypeTrip,
+		StartTime:     start.Timestamp,
+		EndTime:       end.Timestamp,
+		StartPosition: *geojson.NewGeometry(positions[0]),
+		EndPosition:   *geojson.NewGeometry(positions[len(positions)-1]),
+		Path:          *geojson.NewGeometry(orb.LineString(append([]orb.Point(nil), positions...))),
+		Distance:      distance,
+		Duration:      end.Timestamp.Sub(start.Timestamp).Seconds(),
+	}
+}
+
+// newStop creates a stop located at the centroid of the given states.
+func newStop(vehicleID int64, states []vehicleState, positions []orb.Point) trip {
+	var centroid orb.Point
+	for _, p := range positions {
+		centroid[0] += p[0]
+		centroid[1] += p[1]
+	}
+	centroid[0] /= float64(len(positions))
+	centroid[1] /= float64(len(positions))
+	start, end := states[0], states[len(states)-1]
+	return trip{
+		VehicleID:     vehicleID,
+		Type:          tripTypeStop,
+		StartTime:     start.Timestamp,
+		EndTime:       end.Timestamp,
+		StartPosition: *geojson.NewGeometry(positions[0]),
+		EndPosition:   *geojson.NewGeometry(positions[len(positions)-1]),
+		Path:          *geojson.NewGeometry(centroid),
+		Duration:      end.Timestamp.Sub(start.Timestamp).Seconds(),
+	}
+}
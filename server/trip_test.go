@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EricNeid/go-webserver/internal/verify"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// tripTestStates returns states reported every minute, driving north with 10 m/s
+// while moving and standing still with speed 0 otherwise.
+func tripTestStates(moving ...bool) []vehicleState {
+	start := time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC)
+	states := make([]vehicleState, len(moving))
+	lat := 52.0
+	for i, m := range moving {
+		speed := 0.0
+		if m {
+			speed = 10
+			lat += 0.005 // about 550 m
+		}
+		states[i] = vehicleState{
+			VehicleID: 1,
+			Position:  *geojson.NewGeometry(orb.Point{13.0, lat}),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Speed:     &speed,
+		}
+	}
+	return states
+}
+
+func TestSegmentTrips(t *testing.T) {
+	t.Run("stop, trip, stop", func(t *testing.T) {
+		// arrange
+		states := tripTestStates(false, false, false, false, false, false, true, true, true, false, false, false, false, false, false)
+		// action
+		result := segmentTrips(1, states, DefaultTripConfig)
+		// verify
+		verify.Equals(t, 3, len(result))
+		verify.Equals(t, tripTypeStop, result[0].Type)
+		verify.Equals(t, states[0].Timestamp, result[0].StartTime)
+		verify.Equals(t, states[5].Timestamp, result[0].EndTime)
+		verify.Equals(t, 300.0, result[0].Duration)
+		verify.Equals(t, orb.Point{13.0, 52.0}, result[0].Path.Geometry())
+		verify.Equals(t, tripTypeTrip, result[1].Type)
+		verify.Equals(t, states[5].Timestamp, result[1].StartTime)
+		verify.Equals(t, states[9].Timestamp, result[1].EndTime)
+		verify.Equals(t, 5, len(result[1].Path.Geometry().(orb.LineString)))
+		verify.Assert(t, result[1].Distance > 1600 && result[1].Distance < 1700, "unexpected distance %v", result[1].Distance)
+		verify.Equals(t, tripTypeStop, result[2].Type)
+		verify.Equals(t, states[9].Timestamp, result[2].StartTime)
+		verify.Equals(t, states[14].Timestamp, result[2].EndTime)
+	})
+
+	t.Run("short halt is part of trip", func(t *testing.T) {
+		// arrange
+		states := tripTestStates(true, true, false, false, true, true)
+		// action
+		result := segmentTrips(1, states, DefaultTripConfig)
+		// verify
+		verify.Equals(t, 1, len(result))
+		verify.Equals(t, tripTypeTrip, result[0].Type)
+		verify.Equals(t, 6, len(result[0].Path.Geometry().(orb.LineString)))
+	})
+
+	t.Run("short movement is part of stop", func(t *testing.T) {
+		// arrange
+		config := DefaultTripConfig
+		config.MinTripDistance = 1000
+		states := tripTestStates(false, false, false, false, false, false, true, false, false, false, false, false, false)
+		// action
+		result := segmentTrips(1, states, config)
+		// verify
+		verify.Equals(t, 1, len(result))
+		verify.Equals(t, tripTypeStop, result[0].Type)
+		verify.Equals(t, states[0].Timestamp, result[0].StartTime)
+		verify.Equals(t, states[12].Timestamp, result[0].EndTime)
+	})
+
+	t.Run("ongoing trip", func(t *testing.T) {
+		// arrange
+		states := tripTestStates(false, false, false, false, false, false, true, true)
+		// action
+		result := segmentTrips(1, states, DefaultTripConfig)
+		// verify
+		verify.Equals(t, 2, len(result))
+		verify.Equals(t, tripTypeTrip, result[1].Type)
+		verify.Equals(t, states[7].Timestamp, result[1].EndTime)
+	})
+
+	t.Run("no states", func(t *testing.T) {
+		// action
+		result := segmentTrips(1, nil, DefaultTripConfig)
+		// verify
+		verify.Equals(t, 0, len(result))
+	})
+}
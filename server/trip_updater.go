@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// maintainTrips updates the trips of all vehicles with the states committed since the last run,
+// every TripConfig.UpdateInterval until ctx is done. Instances sharing the database may run it
+// concurrently, updateTrips locks the vehicle and skips states already included.
+func (srv ApplicationServer) maintainTrips(ctx context.Context) {
+	if srv.tripConfig.UpdateInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(srv.tripConfig.UpdateInterval)
+	defer ticker.Stop()
+	var lastTxID int64 // all vehicles are checked once after starting
+	for {
+		txID, err := srv.updateTripsCommittedAfter(lastTxID)
+		if err != nil {
+			srv.logger.Printf("Could not update trips: %v\n", err)
+		}
+		lastTxID = txID
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateTripsCommittedAfter updates the trips of the vehicles with states committed by transactions after lastTxID,
+// or of all vehicles if lastTxID is 0. It returns the transaction id up to which all vehicles are updated,
+// which is lastTxID on failure.
+func (srv ApplicationServer) updateTripsCommittedAfter(lastTxID int64) (int64, error) {
+	vehicleIDs, txID, err := getVehiclesCommittedAfter(srv.logger, srv.db, lastTxID)
+	if err != nil {
+		return lastTxID, err
+	}
+	if lastTxID == 0 {
+		// includes states stored before commits were recorded
+		vehicles, err := getVehicles(srv.logger, srv.db)
+		if err != nil {
+			return lastTxID, err
+		}
+		vehicleIDs = make([]int64, len(vehicles))
+		for i, v := range vehicles {
+			vehicleIDs[i] = v.ID
+		}
+	}
+	for _, id := range vehicleIDs {
+		err := updateTrips(srv.logger, srv.db, id, srv.tripConfig)
+		if err != nil {
+			return lastTxID, err
+		}
+	}
+	return txID, nil
+}